@@ -0,0 +1,282 @@
+// Package anomaly raises alerts on peer connections deviating from their
+// history: new locations, unusual hours and a key used by two clients.
+package anomaly
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/turekt/wgmon/geoip"
+	"github.com/turekt/wgmon/history"
+	"github.com/turekt/wgmon/hook"
+	"github.com/turekt/wgmon/metrics"
+)
+
+// Rule names reported in anomaly events.
+const (
+	RuleNewLocation = "new_location"
+	RuleConcurrent  = "concurrent"
+	RuleUnusualHour = "unusual_hour"
+)
+
+// Location dimensions compared by the new location rule.
+const (
+	ByCountry = "country"
+	ByASN     = "asn"
+	ByNetwork = "network"
+)
+
+const (
+	DefaultMinSessions = 10
+	// historyLimit bounds sessions a peer is compared against
+	historyLimit = 1000
+	// hourSpread is how many hours around the connection hour count as usual
+	hourSpread = 1
+)
+
+var anomalies = metrics.Default.Counter(
+	"wgmon_anomalies_total", "Number of raised anomaly alerts.", "rule")
+
+// Rules configures anomaly detection, zero values disable a rule.
+type Rules struct {
+	// Location lists dimensions where a value never seen before in peer
+	// history is reported
+	Location []string
+	// Concurrent is the window within which a peer alternating between
+	// endpoints of two addresses is reported
+	Concurrent time.Duration
+	// Hours is the fraction of past sessions started around the same hour
+	// below which connection is reported
+	Hours float64
+	// MinSessions is the number of sessions a peer needs before its
+	// history is used
+	MinSessions int
+}
+
+// History provides past sessions of peers, implemented by history.Store.
+type History interface {
+	Sessions(q history.Query) ([]history.Session, error)
+}
+
+// Locator resolves location of endpoints, implemented by geoip.DB.
+type Locator interface {
+	Lookup(endpoint string) *geoip.Location
+}
+
+// Detector checks connection events against rules.
+type Detector struct {
+	history History
+
+	mu       sync.Mutex
+	rules    Rules
+	locator  Locator
+	recent   map[string][]visit
+	reported map[string]time.Time
+	// location of hour comparisons, local time unless set
+	tz *time.Location
+}
+
+// visit is an endpoint used by a peer at a time.
+type visit struct {
+	endpoint string
+	at       time.Time
+}
+
+// NewDetector creates detector, history may be nil in which case only
+// rules not using history are evaluated.
+func NewDetector(h History, rules Rules) *Detector {
+	return &Detector{
+		history:  h,
+		rules:    rules,
+		recent:   make(map[string][]visit),
+		reported: make(map[string]time.Time),
+		tz:       time.Local,
+	}
+}
+
+// SetRules replaces rules, state of the concurrent rule is kept.
+func (d *Detector) SetRules(rules Rules) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rules = rules
+}
+
+// SetLocator enables location dimensions other than network, nil disables
+// them.
+func (d *Detector) SetLocator(l Locator) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.locator = l
+}
+
+// Check returns anomalies of opened state and roam event e. It has to be
+// called before e is recorded in history.
+func (d *Detector) Check(e *hook.Event) []*hook.Event {
+	opened := e.Type == hook.EventState && e.State == "opened"
+	if !opened && e.Type != hook.EventRoam {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var found []*hook.Event
+	add := func(rule, reason string) {
+		anomalies.With(rule).Inc()
+		found = append(found, hook.NewAnomalyEvent(e, rule, reason))
+	}
+	if e.Type == hook.EventRoam {
+		if reason := d.concurrent(e); reason != "" {
+			add(RuleConcurrent, reason)
+		}
+	}
+	if d.history == nil || (len(d.rules.Location) == 0 && d.rules.Hours <= 0) {
+		return found
+	}
+
+	sessions, err := d.history.Sessions(history.Query{Device: e.Device, Peer: e.Peer, Limit: historyLimit})
+	if err != nil {
+		slog.Error("anomaly history query failed", "id", e.ID(), "error", err)
+		return found
+	}
+	if len(sessions) < max(d.rules.MinSessions, 1) {
+		return found
+	}
+	if reason := d.newLocation(e, sessions); reason != "" {
+		add(RuleNewLocation, reason)
+	}
+	if opened {
+		if reason := d.unusualHour(e.Time, sessions); reason != "" {
+			add(RuleUnusualHour, reason)
+		}
+	}
+	return found
+}
+
+// concurrent reports peer coming back to an address it left within the
+// window, two clients sharing a key keep taking the endpoint from each
+// other while a roaming client rarely returns that fast.
+func (d *Detector) concurrent(e *hook.Event) string {
+	window := d.rules.Concurrent
+	if window <= 0 {
+		return ""
+	}
+	id := e.ID()
+	visits := slices.DeleteFunc(d.recent[id], func(v visit) bool {
+		return e.Time.Sub(v.at) > window
+	})
+	if len(visits) == 0 {
+		visits = append(visits, visit{e.Previous, e.Time})
+	}
+	visits = append(visits, visit{e.Endpoint, e.Time})
+	d.recent[id] = visits
+
+	curr, prev := host(e.Endpoint), host(e.Previous)
+	if curr == prev {
+		return ""
+	}
+	left := slices.ContainsFunc(visits[:len(visits)-1], func(v visit) bool {
+		return host(v.endpoint) == curr
+	})
+	if !left || e.Time.Sub(d.reported[id]) < window {
+		return ""
+	}
+	d.reported[id] = e.Time
+	return fmt.Sprintf("endpoint alternates between %s and %s within %s, the key is likely used by two clients",
+		e.Previous, e.Endpoint, window)
+}
+
+// newLocation reports dimensions whose value was never seen in sessions.
+func (d *Detector) newLocation(e *hook.Event, sessions []history.Session) string {
+	if len(d.rules.Location) == 0 {
+		return ""
+	}
+	seen := make(map[string]map[string]bool)
+	for _, s := range sessions {
+		for _, endpoint := range s.Endpoints {
+			for by, value := range d.dimensions(endpoint) {
+				if seen[by] == nil {
+					seen[by] = make(map[string]bool)
+				}
+				seen[by][value] = true
+			}
+		}
+	}
+
+	var reasons []string
+	current := d.dimensions(e.Endpoint)
+	for _, by := range d.rules.Location {
+		if value, ok := current[by]; ok && !seen[by][value] {
+			reasons = append(reasons, fmt.Sprintf("new %s %s", by, value))
+		}
+	}
+	if len(reasons) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s, not seen in %d sessions", strings.Join(reasons, ", "), len(sessions))
+}
+
+// dimensions returns values of configured location dimensions of endpoint,
+// unknown values are left out.
+func (d *Detector) dimensions(endpoint string) map[string]string {
+	values := make(map[string]string)
+	ip := net.ParseIP(host(endpoint))
+	if ip == nil {
+		return values
+	}
+	var loc *geoip.Location
+	if d.locator != nil {
+		loc = d.locator.Lookup(endpoint)
+	}
+	for _, by := range d.rules.Location {
+		switch by {
+		case ByNetwork:
+			mask := net.CIDRMask(48, 128)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, mask = ip4, net.CIDRMask(24, 32)
+			}
+			values[by] = (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
+		case ByCountry:
+			if loc != nil && loc.Country != "" {
+				values[by] = loc.Country
+			}
+		case ByASN:
+			if loc != nil && loc.ASN != 0 {
+				values[by] = strings.TrimSpace(fmt.Sprintf("AS%d %s", loc.ASN, loc.Org))
+			}
+		}
+	}
+	return values
+}
+
+// unusualHour reports connection at an hour around which only a small
+// fraction of past sessions started.
+func (d *Detector) unusualHour(at time.Time, sessions []history.Session) string {
+	if d.rules.Hours <= 0 {
+		return ""
+	}
+	hour := at.In(d.tz).Hour()
+	usual := 0
+	for _, s := range sessions {
+		diff := (s.Start.In(d.tz).Hour() - hour + 24) % 24
+		if diff <= hourSpread || diff >= 24-hourSpread {
+			usual++
+		}
+	}
+	if float64(usual)/float64(len(sessions)) >= d.rules.Hours {
+		return ""
+	}
+	return fmt.Sprintf("connected at %02d:%02d, %d of %d sessions started between %02d:00 and %02d:59",
+		hour, at.In(d.tz).Minute(), usual, len(sessions), (hour+24-hourSpread)%24, (hour+hourSpread)%24)
+}
+
+func host(endpoint string) string {
+	if h, _, err := net.SplitHostPort(endpoint); err == nil {
+		return h
+	}
+	return endpoint
+}
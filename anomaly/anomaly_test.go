@@ -0,0 +1,188 @@
+package anomaly
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/turekt/wgmon/geoip"
+	"github.com/turekt/wgmon/history"
+	"github.com/turekt/wgmon/hook"
+)
+
+type fakeHistory struct {
+	sessions []history.Session
+	err      error
+}
+
+func (h *fakeHistory) Sessions(q history.Query) ([]history.Session, error) {
+	return h.sessions, h.err
+}
+
+type fakeLocator map[string]*geoip.Location
+
+func (l fakeLocator) Lookup(endpoint string) *geoip.Location {
+	return l[host(endpoint)]
+}
+
+func openedEvent(endpoint string, at time.Time) *hook.Event {
+	e := hook.NewStateEvent("wg0", "key", endpoint, "opened")
+	e.Time = at
+	return e
+}
+
+func roamEvent(previous, endpoint string, at time.Time) *hook.Event {
+	e := hook.NewRoamEvent("wg0", "key", previous, endpoint)
+	e.Time = at
+	return e
+}
+
+// rules returns names of rules raised for e.
+func rules(d *Detector, e *hook.Event) string {
+	var names []string
+	for _, a := range d.Check(e) {
+		names = append(names, a.Anomaly)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestNewLocation(t *testing.T) {
+	start := time.Date(2024, 5, 14, 9, 0, 0, 0, time.UTC)
+	h := &fakeHistory{}
+	for i := 0; i < 10; i++ {
+		h.sessions = append(h.sessions, history.Session{
+			Endpoints: []string{"81.2.69.10:51820", "81.2.70.10:51820"},
+			Start:     start.Add(-time.Duration(i) * 24 * time.Hour),
+		})
+	}
+	locator := fakeLocator{
+		"81.2.69.10": {Country: "GB", ASN: 20712, Org: "Andrews & Arnold Ltd"},
+		"81.2.70.10": {Country: "GB", ASN: 20712, Org: "Andrews & Arnold Ltd"},
+		"81.2.69.99": {Country: "GB", ASN: 20712, Org: "Andrews & Arnold Ltd"},
+		"81.2.71.10": {Country: "GB", ASN: 20712, Org: "Andrews & Arnold Ltd"},
+		"5.9.0.1":    {Country: "DE", ASN: 24940, Org: "Hetzner Online GmbH"},
+	}
+	d := NewDetector(h, Rules{Location: []string{ByCountry, ByASN, ByNetwork}, MinSessions: 10})
+	d.SetLocator(locator)
+
+	testCases := []struct {
+		endpoint string
+		expect   string
+	}{
+		{"81.2.69.99:51820", ""},
+		{"81.2.71.10:51820", "new network 81.2.71.0/24, not seen in 10 sessions"},
+		{"5.9.0.1:51820", "new country DE, new asn AS24940 Hetzner Online GmbH, new network 5.9.0.0/24, not seen in 10 sessions"},
+		{"[2001:db8::1]:51820", "new network 2001:db8::/48, not seen in 10 sessions"},
+	}
+	for i, tc := range testCases {
+		got := ""
+		if found := d.Check(openedEvent(tc.endpoint, start)); len(found) > 0 {
+			if found[0].Type != hook.EventAnomaly || found[0].Anomaly != RuleNewLocation {
+				t.Errorf("case #%d %s, unexpected anomaly: %v", i, tc.endpoint, found[0])
+			}
+			got = found[0].Reason
+		}
+		if got != tc.expect {
+			t.Errorf("case #%d %s, unexpected reason: got %q, want %q", i, tc.endpoint, got, tc.expect)
+		}
+	}
+
+	// without a locator only the network is known
+	d.SetLocator(nil)
+	if got, want := d.Check(openedEvent("5.9.0.1:51820", start))[0].Reason, "new network 5.9.0.0/24, not seen in 10 sessions"; got != want {
+		t.Errorf("unexpected reason without locator: got %q, want %q", got, want)
+	}
+
+	// too short history
+	d.SetRules(Rules{Location: []string{ByNetwork}, MinSessions: 11})
+	if got := rules(d, openedEvent("5.9.0.1:51820", start)); got != "" {
+		t.Errorf("unexpected anomaly with short history: %v", got)
+	}
+
+	h.err = errors.New("failed")
+	d.SetRules(Rules{Location: []string{ByNetwork}})
+	if got := rules(d, openedEvent("5.9.0.1:51820", start)); got != "" {
+		t.Errorf("unexpected anomaly on history error: %v", got)
+	}
+}
+
+func TestUnusualHour(t *testing.T) {
+	day := time.Date(2024, 5, 14, 0, 0, 0, 0, time.UTC)
+	h := &fakeHistory{}
+	// 18 sessions around 9:00 and 2 around 23:00
+	for i := 0; i < 20; i++ {
+		hour := 9 + i%3 - 1
+		if i >= 18 {
+			hour = 23
+		}
+		h.sessions = append(h.sessions, history.Session{
+			Endpoints: []string{"81.2.69.10:51820"},
+			Start:     day.Add(-time.Duration(i)*24*time.Hour + time.Duration(hour)*time.Hour),
+		})
+	}
+	d := NewDetector(h, Rules{Hours: 0.2, MinSessions: 10})
+	d.tz = time.UTC
+
+	testCases := []struct {
+		hour   int
+		expect string
+	}{
+		{9, ""},
+		{10, ""},
+		{7, ""},
+		{0, "connected at 00:30, 2 of 20 sessions started between 23:00 and 01:59"},
+		{4, "connected at 04:30, 0 of 20 sessions started between 03:00 and 05:59"},
+	}
+	for i, tc := range testCases {
+		got := ""
+		e := openedEvent("81.2.69.10:51820", day.Add(time.Duration(tc.hour)*time.Hour+30*time.Minute))
+		if found := d.Check(e); len(found) > 0 {
+			got = found[0].Reason
+		}
+		if got != tc.expect {
+			t.Errorf("case #%d %d:30, unexpected reason: got %q, want %q", i, tc.hour, got, tc.expect)
+		}
+	}
+
+	// roaming does not start a session
+	if got := rules(d, roamEvent("81.2.69.10:51820", "81.2.69.10:40000", day.Add(4*time.Hour))); got != "" {
+		t.Errorf("unexpected anomaly on roam: %v", got)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	start := time.Date(2024, 5, 14, 9, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time {
+		return start.Add(time.Duration(minutes) * time.Minute)
+	}
+	d := NewDetector(nil, Rules{Concurrent: 10 * time.Minute})
+
+	testCases := []struct {
+		previous, endpoint string
+		minutes            int
+		expect             string
+	}{
+		// port change behind the same NAT
+		{"1.1.1.1:1000", "1.1.1.1:2000", 0, ""},
+		{"1.1.1.1:2000", "2.2.2.2:1000", 1, ""},
+		{"2.2.2.2:1000", "1.1.1.1:2000", 2, RuleConcurrent},
+		// reported once per window
+		{"1.1.1.1:2000", "2.2.2.2:1000", 3, ""},
+		{"2.2.2.2:1000", "1.1.1.1:2000", 4, ""},
+		// roaming back after the window
+		{"1.1.1.1:2000", "3.3.3.3:1000", 30, ""},
+		{"3.3.3.3:1000", "1.1.1.1:2000", 45, ""},
+		{"1.1.1.1:2000", "3.3.3.3:1000", 50, RuleConcurrent},
+	}
+	for i, tc := range testCases {
+		if got := rules(d, roamEvent(tc.previous, tc.endpoint, at(tc.minutes))); got != tc.expect {
+			t.Errorf("case #%d %s -> %s, unexpected anomaly: got %q, want %q", i, tc.previous, tc.endpoint, got, tc.expect)
+		}
+	}
+
+	d.SetRules(Rules{})
+	if got := rules(d, roamEvent("3.3.3.3:1000", "1.1.1.1:2000", at(70))); got != "" {
+		t.Errorf("unexpected anomaly when disabled: %v", got)
+	}
+}
@@ -0,0 +1,20 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/turekt/wgmon/hook"
+)
+
+// NotifyTester sends test notifications through configured sinks.
+type NotifyTester interface {
+	NotifyTest() []hook.SendResult
+}
+
+// SetControl registers endpoints changing daemon behaviour. They are meant
+// for the local control socket only and not for the public API.
+func (s *Server) SetControl(tester NotifyTester) {
+	s.mux.HandleFunc("POST /api/v1/notify-test", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, tester.NotifyTest())
+	})
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+//go:embed dashboard
+var dashboardFS embed.FS
+
+// dashboardPrefix is served without authentication, the dashboard asks
+// for API token and sends it with API requests.
+const dashboardPrefix = "/ui/"
+
+// SetDashboard serves embedded web dashboard on /ui/ presenting devices,
+// peers and recent events from API endpoints.
+func (s *Server) SetDashboard() {
+	static, err := fs.Sub(dashboardFS, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+	s.mux.Handle("GET "+dashboardPrefix, http.StripPrefix(dashboardPrefix, http.FileServerFS(static)))
+	s.mux.Handle("GET /{$}", http.RedirectHandler(dashboardPrefix, http.StatusFound))
+	s.public = func(r *http.Request) bool {
+		return r.URL.Path == "/" || strings.HasPrefix(r.URL.Path, dashboardPrefix)
+	}
+}
@@ -0,0 +1,251 @@
+"use strict";
+
+const refreshInterval = 5000;
+const timelineWindow = 6 * 60 * 60 * 1000;
+const sparkSamples = 60;
+const maxEvents = 50;
+
+let lastEventID = 0;
+let events = [];
+// per peer bandwidth samples and previous byte counters
+const bandwidth = new Map();
+
+function token() {
+  return localStorage.getItem("wgmon-token") || "";
+}
+
+async function get(path) {
+  const headers = {};
+  if (token()) {
+    headers["Authorization"] = "Bearer " + token();
+  }
+  const resp = await fetch(path, { headers });
+  if (resp.status === 401) {
+    throw new Error("unauthorized");
+  }
+  if (!resp.ok) {
+    throw new Error(path + ": " + resp.status);
+  }
+  return resp.json();
+}
+
+function el(tag, attrs, ...children) {
+  const e = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs || {})) {
+    e.setAttribute(k, v);
+  }
+  for (const c of children) {
+    e.append(c instanceof Node ? c : document.createTextNode(c ?? ""));
+  }
+  return e;
+}
+
+function svg(tag, attrs) {
+  const e = document.createElementNS("http://www.w3.org/2000/svg", tag);
+  for (const [k, v] of Object.entries(attrs || {})) {
+    e.setAttribute(k, v);
+  }
+  return e;
+}
+
+function shortKey(key) {
+  return el("span", { class: "key", title: key }, key.slice(0, 10) + "…");
+}
+
+function peerName(key, name) {
+  return name ? el("span", { title: key }, name) : shortKey(key);
+}
+
+function ago(time) {
+  if (!time) {
+    return "never";
+  }
+  const s = Math.round((Date.now() - new Date(time)) / 1000);
+  if (s < 60) return s + "s ago";
+  if (s < 3600) return Math.round(s / 60) + "m ago";
+  if (s < 86400) return Math.round(s / 3600) + "h ago";
+  return Math.round(s / 86400) + "d ago";
+}
+
+function rate(bps) {
+  const units = ["B/s", "KB/s", "MB/s", "GB/s"];
+  let i = 0;
+  while (bps >= 1024 && i < units.length - 1) {
+    bps /= 1024;
+    i++;
+  }
+  return bps.toFixed(i ? 1 : 0) + " " + units[i];
+}
+
+function peerID(device, key) {
+  return device + ":" + key;
+}
+
+function sampleBandwidth(peers, now) {
+  for (const p of peers) {
+    const id = peerID(p.device, p.public_key);
+    const bytes = p.receive_bytes + p.transmit_bytes;
+    const b = bandwidth.get(id) || { samples: [] };
+    if (b.bytes !== undefined && bytes >= b.bytes) {
+      b.samples.push((bytes - b.bytes) / ((now - b.time) / 1000));
+      b.samples = b.samples.slice(-sparkSamples);
+    }
+    b.bytes = bytes;
+    b.time = now;
+    bandwidth.set(id, b);
+  }
+}
+
+function sparkline(id) {
+  const b = bandwidth.get(id);
+  const width = 120, height = 20;
+  const s = svg("svg", { class: "spark", width, height });
+  if (!b || b.samples.length < 2) {
+    return el("span", { class: "muted" }, "collecting…");
+  }
+  const max = Math.max(...b.samples, 1);
+  const step = width / (sparkSamples - 1);
+  const offset = width - (b.samples.length - 1) * step;
+  const points = b.samples.map((v, i) =>
+    (offset + i * step).toFixed(1) + "," + (height - 1 - (v / max) * (height - 2)).toFixed(1));
+  s.append(svg("polyline", { points: points.join(" ") }));
+  return el("span", { title: "peak " + rate(max) }, s, " " + rate(b.samples[b.samples.length - 1]));
+}
+
+// sessions computes connected intervals of a peer within timeline window
+// from buffered state events and current session start.
+function sessions(p, now) {
+  const start = now - timelineWindow;
+  const intervals = [];
+  let open = null;
+  for (const e of events) {
+    if (e.type !== "state" || e.device !== p.device || e.peer !== p.public_key) {
+      continue;
+    }
+    const t = new Date(e.time).getTime();
+    if (e.state === "closed") {
+      intervals.push([open ?? start, t]);
+      open = null;
+    } else if (open === null) {
+      open = t;
+    }
+  }
+  if (p.connected) {
+    const since = p.session_start ? new Date(p.session_start).getTime() : open;
+    intervals.push([since ?? start, now]);
+  }
+  return intervals
+    .map(([a, b]) => [Math.max(a, start), b])
+    .filter(([a, b]) => b > start && b >= a);
+}
+
+function timeline(p, now) {
+  const width = 200, height = 12;
+  const s = svg("svg", { class: "timeline", width, height });
+  s.append(svg("rect", { class: "bg", width, height }));
+  const start = now - timelineWindow;
+  for (const [a, b] of sessions(p, now)) {
+    const x = ((a - start) / timelineWindow) * width;
+    const w = Math.max(((b - a) / timelineWindow) * width, 1);
+    const r = svg("rect", { class: "session", x: x.toFixed(1), width: w.toFixed(1), height });
+    const title = svg("title");
+    title.textContent = new Date(a).toLocaleTimeString() + " – " + new Date(b).toLocaleTimeString();
+    r.append(title);
+    s.append(r);
+  }
+  return s;
+}
+
+function eventDetails(e) {
+  switch (e.type) {
+    case "state":
+      return e.state + (e.endpoint ? " from " + e.endpoint : "");
+    case "roam":
+      return e.previous_endpoint + " → " + e.endpoint;
+    case "packet":
+      return e.packet ? e.packet.SrcIP + ":" + e.packet.SrcPort + " → " + e.packet.DstIP + ":" + e.packet.DstPort : "";
+    case "flapping":
+      return e.count + " state changes";
+    case "digest":
+      return (e.events || []).length + " events";
+    case "attempts":
+      return e.count + " packets from " + (e.attempts || []).map((a) => a.source + " (" + a.class + ")").join(", ");
+    case "anomaly":
+      return e.anomaly + ": " + e.reason;
+    case "bandwidth":
+      return e.bandwidth ? e.bandwidth.scope + " " + e.bandwidth.group + " " + e.state + " " + e.bandwidth.direction + " limit" : "";
+    default:
+      return e.state || "";
+  }
+}
+
+function render(devices, peers, now) {
+  const online = peers.filter((p) => p.connected).length;
+  document.getElementById("summary").textContent = online + " of " + peers.length + " peers online";
+  document.getElementById("updated").textContent = "updated " + new Date(now).toLocaleTimeString();
+  document.getElementById("window").textContent = timelineWindow / 3600000 + "h";
+
+  document.getElementById("devices").replaceChildren(...devices.map((d) =>
+    el("tr", {}, el("td", {}, d.name), el("td", {}, shortKey(d.public_key)), el("td", {}, String(d.listen_port)),
+      el("td", {}, String(d.peers)), el("td", {}, String(d.connected)))));
+
+  peers.sort((a, b) => (b.connected - a.connected) || a.device.localeCompare(b.device) ||
+    (a.name || a.public_key).localeCompare(b.name || b.public_key));
+  document.getElementById("peers").replaceChildren(...peers.map((p) =>
+    el("tr", { class: p.connected ? "online" : "offline" },
+      el("td", { title: p.connected ? "online" : "offline" }, el("span", { class: "dot" })),
+      el("td", {}, p.device),
+      el("td", {}, peerName(p.public_key, p.name)),
+      el("td", { title: p.location ? [p.location.city, p.location.country_name, p.location.org].filter(Boolean).join(", ") : "" },
+        (p.endpoint || "") + (p.location && p.location.country ? " (" + p.location.country + ")" : "")),
+      el("td", {}, p.state),
+      el("td", { title: p.last_handshake || "" }, ago(p.last_handshake)),
+      el("td", {}, timeline(p, now)),
+      el("td", {}, sparkline(peerID(p.device, p.public_key))))));
+
+  document.getElementById("events").replaceChildren(...events.slice(-maxEvents).reverse().map((e) =>
+    el("tr", {}, el("td", { title: e.time }, new Date(e.time).toLocaleString()), el("td", {}, e.type),
+      el("td", {}, e.device || ""), el("td", {}, e.peer ? peerName(e.peer, e.name) : ""), el("td", {}, eventDetails(e)))));
+}
+
+async function refresh() {
+  let [devices, peers, latest] = await Promise.all([
+    get("/api/v1/devices"),
+    get("/api/v1/peers"),
+    get("/api/v1/events?last_event_id=" + lastEventID),
+  ]);
+  devices = devices || [];
+  peers = peers || [];
+  const now = Date.now();
+  for (const e of latest) {
+    lastEventID = Math.max(lastEventID, e.id);
+  }
+  events = events.concat(latest).filter((e) => now - new Date(e.time) < timelineWindow);
+  sampleBandwidth(peers, now);
+  render(devices, peers, now);
+}
+
+async function loop() {
+  try {
+    await refresh();
+    document.getElementById("login").hidden = true;
+    document.getElementById("content").hidden = false;
+  } catch (err) {
+    if (err.message === "unauthorized") {
+      document.getElementById("content").hidden = true;
+      document.getElementById("login").hidden = false;
+      document.getElementById("login-error").textContent = token() ? "invalid token" : "";
+      return;
+    }
+    document.getElementById("updated").textContent = "update failed: " + err.message;
+  }
+  setTimeout(loop, refreshInterval);
+}
+
+document.getElementById("login").addEventListener("submit", (ev) => {
+  ev.preventDefault();
+  localStorage.setItem("wgmon-token", document.getElementById("token").value);
+  loop();
+});
+
+loop();
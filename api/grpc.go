@@ -0,0 +1,298 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/turekt/wgmon/api/wgmonpb"
+	"github.com/turekt/wgmon/geoip"
+	"github.com/turekt/wgmon/hook"
+	"github.com/turekt/wgmon/network"
+	"github.com/turekt/wgmon/wg"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPCServer serves tracker state and events over gRPC as defined in
+// wgmonpb/wgmon.proto. Authentication mirrors the JSON API: bearer token
+// in authorization metadata and/or client certificates over mTLS.
+type GRPCServer struct {
+	wgmonpb.UnimplementedMonitorServer
+
+	tracker Tracker
+	stream  *Stream
+	token   string
+	srv     *grpc.Server
+}
+
+func NewGRPCServer(tracker Tracker, stream *Stream, token string, tlsConfig *tls.Config) *GRPCServer {
+	s := &GRPCServer{
+		tracker: tracker,
+		stream:  stream,
+		token:   token,
+	}
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(s.unaryAuth),
+		grpc.StreamInterceptor(s.streamAuth),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	s.srv = grpc.NewServer(opts...)
+	wgmonpb.RegisterMonitorServer(s.srv, s)
+	return s
+}
+
+// ListenAndServe serves gRPC on addr, which is either host:port or
+// unix:///path/to/socket.
+func (s *GRPCServer) ListenAndServe(addr string) error {
+	l, err := Listen(addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("serving grpc", "addr", addr)
+	if err := s.srv.Serve(l); !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+// Close stops the server, closing open event watches.
+func (s *GRPCServer) Close() error {
+	s.srv.Stop()
+	return nil
+}
+
+func (s *GRPCServer) authorize(ctx context.Context) error {
+	if s.token == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		token, ok := strings.CutPrefix(v, "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "unauthorized")
+}
+
+func (s *GRPCServer) unaryAuth(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *GRPCServer) streamAuth(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.authorize(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+func (s *GRPCServer) ListDevices(ctx context.Context, req *wgmonpb.ListDevicesRequest) (*wgmonpb.ListDevicesResponse, error) {
+	devices, _, err := s.tracker.Status()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &wgmonpb.ListDevicesResponse{}
+	for _, d := range devices {
+		resp.Devices = append(resp.Devices, deviceProto(&d))
+	}
+	return resp, nil
+}
+
+func (s *GRPCServer) ListPeers(ctx context.Context, req *wgmonpb.ListPeersRequest) (*wgmonpb.ListPeersResponse, error) {
+	_, peers, err := s.tracker.Status()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &wgmonpb.ListPeersResponse{}
+	for _, p := range peers {
+		if req.Device != "" && p.Device != req.Device {
+			continue
+		}
+		if req.Connected != nil && p.Connected != req.GetConnected() {
+			continue
+		}
+		resp.Peers = append(resp.Peers, peerProto(&p))
+	}
+	return resp, nil
+}
+
+func (s *GRPCServer) GetPeer(ctx context.Context, req *wgmonpb.GetPeerRequest) (*wgmonpb.Peer, error) {
+	_, peers, err := s.tracker.Status()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	key := NormalizeKey(req.PublicKey)
+	for _, p := range peers {
+		if p.Device == req.Device && p.PublicKey == key {
+			return peerProto(&p), nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "peer %s not found on device %s", key, req.Device)
+}
+
+func (s *GRPCServer) WatchEvents(req *wgmonpb.WatchEventsRequest, ws wgmonpb.Monitor_WatchEventsServer) error {
+	f := EventFilter{Devices: req.Devices}
+	for _, p := range req.Peers {
+		f.Peers = append(f.Peers, NormalizeKey(p))
+	}
+	for _, t := range req.Types {
+		// unspecified type selects nothing, same as no type filter
+		if t != wgmonpb.EventType_EVENT_TYPE_UNSPECIFIED {
+			f.Types = append(f.Types, hook.EventType(t).String())
+		}
+	}
+	lastID := noBacklog
+	if req.LastEventId != nil {
+		lastID = req.GetLastEventId()
+	}
+
+	backlog, sub := s.stream.Subscribe(f, lastID)
+	defer s.stream.Unsubscribe(sub)
+	for _, se := range backlog {
+		if err := ws.Send(eventProto(se.ID, se.Event)); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case se, ok := <-sub.C:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "subscriber too slow, resume with last event id")
+			}
+			if err := ws.Send(eventProto(se.ID, se.Event)); err != nil {
+				return err
+			}
+		case <-ws.Context().Done():
+			return nil
+		}
+	}
+}
+
+func deviceProto(d *wg.DeviceStatus) *wgmonpb.Device {
+	return &wgmonpb.Device{
+		Name:       d.Name,
+		Type:       d.Type,
+		PublicKey:  d.PublicKey,
+		ListenPort: int32(d.ListenPort),
+		Peers:      int32(d.Peers),
+		Connected:  int32(d.Connected),
+	}
+}
+
+func peerProto(p *wg.PeerStatus) *wgmonpb.Peer {
+	return &wgmonpb.Peer{
+		Device:        p.Device,
+		PublicKey:     p.PublicKey,
+		Endpoint:      p.Endpoint,
+		AllowedIps:    p.AllowedIPs,
+		State:         p.State,
+		Connected:     p.Connected,
+		LastHandshake: timestampProto(p.LastHandshake),
+		ReceiveBytes:  p.ReceiveBytes,
+		TransmitBytes: p.TransmitBytes,
+		SessionStart:  timestampProto(p.SessionStart),
+		Name:          p.Name,
+		Owner:         p.Owner,
+		Tags:          p.Tags,
+		Location:      locationProto(p.Location),
+	}
+}
+
+func eventProto(id uint64, e *hook.Event) *wgmonpb.Event {
+	pe := &wgmonpb.Event{
+		Id:               id,
+		Type:             wgmonpb.EventType(e.Type),
+		Time:             timestamppb.New(e.Time),
+		Device:           e.Device,
+		Peer:             e.Peer,
+		Endpoint:         e.Endpoint,
+		State:            e.State,
+		Packet:           packetProto(e.Packet),
+		Count:            int32(e.Count),
+		PreviousEndpoint: e.Previous,
+		Message:          e.Message(),
+		Name:             e.Name,
+		Owner:            e.Owner,
+		Tags:             e.Tags,
+		Location:         locationProto(e.Location),
+		Anomaly:          e.Anomaly,
+		Reason:           e.Reason,
+	}
+	if u := e.Bandwidth; u != nil {
+		pe.Bandwidth = &wgmonpb.Usage{
+			Scope:         u.Scope,
+			Group:         u.Group,
+			Direction:     u.Direction,
+			Value:         u.Value,
+			Limit:         u.Limit,
+			WindowSeconds: int64(u.Window.Seconds()),
+			Period:        u.Period,
+		}
+	}
+	for _, child := range e.Events {
+		pe.Events = append(pe.Events, eventProto(0, child))
+	}
+	for _, a := range e.Attempts {
+		pe.Attempts = append(pe.Attempts, &wgmonpb.Attempt{
+			Source:   a.Source,
+			Class:    a.Class,
+			Count:    int32(a.Count),
+			First:    timestamppb.New(a.First),
+			Last:     timestamppb.New(a.Last),
+			Device:   a.Device,
+			Peer:     a.Peer,
+			Name:     a.Name,
+			Location: locationProto(a.Location),
+		})
+	}
+	return pe
+}
+
+func locationProto(l *geoip.Location) *wgmonpb.Location {
+	if l == nil {
+		return nil
+	}
+	return &wgmonpb.Location{
+		Country:     l.Country,
+		CountryName: l.CountryName,
+		City:        l.City,
+		Asn:         uint32(l.ASN),
+		Org:         l.Org,
+	}
+}
+
+func packetProto(p *network.PacketDetails) *wgmonpb.Packet {
+	if p == nil {
+		return nil
+	}
+	return &wgmonpb.Packet{
+		SrcIp:   p.SrcIP,
+		DstIp:   p.DstIP,
+		SrcPort: p.SrcPort,
+		DstPort: p.DstPort,
+		Time:    timestampProto(&p.Time),
+		L4Proto: p.L4Proto,
+		L5Proto: p.L5Proto,
+	}
+}
+
+func timestampProto(t *time.Time) *timestamppb.Timestamp {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
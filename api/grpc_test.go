@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/turekt/wgmon/api/wgmonpb"
+	"github.com/turekt/wgmon/hook"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+func newGRPCClient(t *testing.T, s *GRPCServer) wgmonpb.MonitorClient {
+	l := bufconn.Listen(1 << 20)
+	go s.srv.Serve(l)
+	t.Cleanup(func() { s.Close() })
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("unable to create grpc client: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return wgmonpb.NewMonitorClient(conn)
+}
+
+func TestGRPCServer(t *testing.T) {
+	client := newGRPCClient(t, NewGRPCServer(newTrackerMock(), NewStream(0), "secret", nil))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := client.ListDevices(ctx, &wgmonpb.ListDevicesRequest{})
+	if got, want := status.Code(err), codes.Unauthenticated; got != want {
+		t.Errorf("unexpected code without token: got %s, want %s", got, want)
+	}
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+	devices, err := client.ListDevices(ctx, &wgmonpb.ListDevicesRequest{})
+	if err != nil {
+		t.Fatalf("list devices failed: %v", err)
+	}
+	if got, want := len(devices.Devices), 2; got != want {
+		t.Errorf("unexpected device count: got %d, want %d", got, want)
+	}
+
+	testCases := []struct {
+		req    *wgmonpb.ListPeersRequest
+		expect int
+	}{
+		{&wgmonpb.ListPeersRequest{}, 3},
+		{&wgmonpb.ListPeersRequest{Connected: proto.Bool(true)}, 1},
+		{&wgmonpb.ListPeersRequest{Connected: proto.Bool(false)}, 2},
+		{&wgmonpb.ListPeersRequest{Device: "wg1"}, 1},
+	}
+	for i, tc := range testCases {
+		peers, err := client.ListPeers(ctx, tc.req)
+		if err != nil {
+			t.Fatalf("case #%d, list peers failed: %v", i, err)
+		}
+		if got, want := len(peers.Peers), tc.expect; got != want {
+			t.Errorf("case #%d, unexpected peer count: got %d, want %d", i, got, want)
+		}
+	}
+
+	peer, err := client.GetPeer(ctx, &wgmonpb.GetPeerRequest{Device: "wg0", PublicKey: "a-b_c="})
+	if err != nil {
+		t.Fatalf("get peer failed: %v", err)
+	}
+	if got, want := peer.State, "established"; got != want {
+		t.Errorf("unexpected peer state: got %s, want %s", got, want)
+	}
+	_, err = client.GetPeer(ctx, &wgmonpb.GetPeerRequest{Device: "wg1", PublicKey: "def="})
+	if got, want := status.Code(err), codes.NotFound; got != want {
+		t.Errorf("unexpected code of missing peer: got %s, want %s", got, want)
+	}
+}
+
+func TestGRPCWatchEvents(t *testing.T) {
+	stream := NewStream(0)
+	client := newGRPCClient(t, NewGRPCServer(newTrackerMock(), stream, "", nil))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream.Send(hook.NewStateEvent("wg0", "a=", "", "established"))
+	stream.Send(hook.NewRoamEvent("wg0", "a=", "1.1.1.1:1", "2.2.2.2:2"))
+
+	watch, err := client.WatchEvents(ctx, &wgmonpb.WatchEventsRequest{
+		Types:       []wgmonpb.EventType{wgmonpb.EventType_EVENT_TYPE_STATE},
+		LastEventId: proto.Uint64(0),
+	})
+	if err != nil {
+		t.Fatalf("watch events failed: %v", err)
+	}
+	e, err := watch.Recv()
+	if err != nil {
+		t.Fatalf("unable to receive backlog event: %v", err)
+	}
+	if got, want := e.Id, uint64(1); got != want {
+		t.Errorf("unexpected backlog event id: got %d, want %d", got, want)
+	}
+
+	stream.Send(hook.NewRoamEvent("wg0", "a=", "2.2.2.2:2", "3.3.3.3:3"))
+	stream.Send(hook.NewStateEvent("wg0", "a=", "", "closed"))
+	e, err = watch.Recv()
+	if err != nil {
+		t.Fatalf("unable to receive live event: %v", err)
+	}
+	if got, want := e.Id, uint64(4); got != want {
+		t.Errorf("unexpected live event id: got %d, want %d", got, want)
+	}
+	if got, want := e.State, "closed"; got != want {
+		t.Errorf("unexpected live event state: got %s, want %s", got, want)
+	}
+	if e.Message == "" {
+		t.Errorf("expected rendered event message")
+	}
+
+	// unspecified type does not filter events
+	watch, err = client.WatchEvents(ctx, &wgmonpb.WatchEventsRequest{
+		Types:       []wgmonpb.EventType{wgmonpb.EventType_EVENT_TYPE_UNSPECIFIED},
+		LastEventId: proto.Uint64(1),
+	})
+	if err != nil {
+		t.Fatalf("watch events failed: %v", err)
+	}
+	e, err = watch.Recv()
+	if err != nil {
+		t.Fatalf("unable to receive unfiltered event: %v", err)
+	}
+	if got, want := e.Id, uint64(2); got != want {
+		t.Errorf("unexpected unfiltered event id: got %d, want %d", got, want)
+	}
+}
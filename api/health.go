@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/turekt/wgmon/wg"
+)
+
+// HealthChecker reports tracker liveness and readiness.
+type HealthChecker interface {
+	Liveness() []wg.HealthCheck
+	Readiness() []wg.HealthCheck
+}
+
+type healthResponse struct {
+	Status string           `json:"status"`
+	Checks []wg.HealthCheck `json:"checks"`
+}
+
+// HandleHealth registers unauthenticated /healthz and /readyz endpoints
+// answering 503 when any of the checks fails.
+func HandleHealth(mux *http.ServeMux, checker HealthChecker) {
+	mux.Handle("GET /healthz", healthHandler(checker.Liveness))
+	mux.Handle("GET /readyz", healthHandler(checker.Readiness))
+}
+
+func healthHandler(checks func() []wg.HealthCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := healthResponse{Status: "ok", Checks: checks()}
+		status := http.StatusOK
+		for _, c := range resp.Checks {
+			if !c.OK {
+				resp.Status = "fail"
+				status = http.StatusServiceUnavailable
+			}
+		}
+		writeJSON(w, status, resp)
+	}
+}
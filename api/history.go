@@ -0,0 +1,83 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/turekt/wgmon/history"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// SessionHistory provides recorded peer sessions.
+type SessionHistory interface {
+	Sessions(q history.Query) ([]history.Session, error)
+}
+
+// SetHistory exposes recorded sessions filtered by device, peer (public key
+// or name), endpoint (address or IP) and time range. at selects sessions
+// ongoing at a single point in time.
+func (s *Server) SetHistory(h SessionHistory) {
+	s.mux.HandleFunc("GET /api/v1/sessions", func(w http.ResponseWriter, r *http.Request) {
+		q, err := sessionQuery(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		sessions, err := h.Sessions(q)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, sessions)
+	})
+}
+
+func sessionQuery(r *http.Request) (history.Query, error) {
+	v := r.URL.Query()
+	q := history.Query{
+		Device:   v.Get("device"),
+		Peer:     v.Get("peer"),
+		Endpoint: v.Get("endpoint"),
+	}
+	// peer is either a URL safe public key or a peer name
+	if _, err := wgtypes.ParseKey(NormalizeKey(q.Peer)); err == nil {
+		q.Peer = NormalizeKey(q.Peer)
+	}
+
+	parseTime := func(name string) (time.Time, error) {
+		value := v.Get(name)
+		if value == "" {
+			return time.Time{}, nil
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return t, fmt.Errorf("invalid %s %q, expected RFC 3339 time", name, value)
+		}
+		return t, nil
+	}
+	var err error
+	if q.From, err = parseTime("from"); err != nil {
+		return q, err
+	}
+	if q.To, err = parseTime("to"); err != nil {
+		return q, err
+	}
+	at, err := parseTime("at")
+	if err != nil {
+		return q, err
+	}
+	if !at.IsZero() {
+		q.From, q.To = at, at
+	}
+
+	if limit := v.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("invalid limit %q", limit)
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
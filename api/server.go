@@ -0,0 +1,238 @@
+package api
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/turekt/wgmon/wg"
+)
+
+// Tracker provides the state exposed through the API.
+type Tracker interface {
+	Status() ([]wg.DeviceStatus, []wg.PeerStatus, error)
+}
+
+// Server is a read-only HTTP JSON API over tracker state. Requests are
+// authenticated with a bearer token when configured and/or with client
+// certificates when served over mTLS.
+type Server struct {
+	tracker Tracker
+	token   string
+	mux     *http.ServeMux
+	srv     *http.Server
+
+	// public reports requests served without authentication
+	public func(r *http.Request) bool
+}
+
+func NewServer(tracker Tracker, token string) *Server {
+	s := &Server{
+		tracker: tracker,
+		token:   token,
+		mux:     http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /api/v1/devices", s.handleDevices)
+	s.mux.HandleFunc("GET /api/v1/peers", s.handlePeers)
+	s.mux.HandleFunc("GET /api/v1/devices/{device}/peers", s.handlePeers)
+	s.mux.HandleFunc("GET /api/v1/devices/{device}/peers/{key}", s.handlePeer)
+	return s
+}
+
+// SetStream exposes tracker events recorded by stream as JSON list,
+// Server-Sent Events and WebSocket endpoints.
+func (s *Server) SetStream(stream *Stream) {
+	s.mux.HandleFunc("GET /api/v1/events", stream.HandleRecent)
+	s.mux.HandleFunc("GET /api/v1/events/sse", stream.HandleSSE)
+	s.mux.HandleFunc("GET /api/v1/events/ws", stream.HandleWebSocket)
+}
+
+// Handle registers additional handler which is served behind the same
+// authentication as API endpoints.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" && !s.authorized(r) && (s.public == nil || !s.public(r)) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="wgmon"`)
+		writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// ListenAndServe serves API on addr, which is either host:port or
+// unix:///path/to/socket. With non-nil tlsConfig the API is served over TLS.
+func (s *Server) ListenAndServe(addr string, tlsConfig *tls.Config) error {
+	l, err := Listen(addr)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+
+	s.srv = &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	slog.Info("serving api", "addr", addr, "tls", tlsConfig != nil)
+	if err := s.srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) Close() error {
+	if s.srv == nil {
+		return nil
+	}
+	return s.srv.Close()
+}
+
+// Listen opens a TCP listener or, for unix:// addresses, a unix socket
+// replacing a stale socket file left behind by previous run. Sockets still
+// accepting connections and other files are left in place.
+func Listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix://")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("failed to listen on %s: not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to listen on %s: %w", path, syscall.EADDRINUSE)
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %w", path, err)
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// NewTLSConfig loads server certificate and, if clientCA is provided,
+// requires clients to present a certificate signed by it.
+func NewTLSConfig(certFile, keyFile, clientCA string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load api certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCA == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(clientCA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client ca %s: %w", clientCA, err)
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client ca %s", clientCA)
+	}
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
+
+func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	devices, _, err := s.tracker.Status()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, devices)
+}
+
+func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
+	_, peers, err := s.tracker.Status()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	device := r.PathValue("device")
+	if device == "" {
+		device = r.URL.Query().Get("device")
+	}
+	connected := r.URL.Query().Get("connected")
+	filtered := make([]wg.PeerStatus, 0, len(peers))
+	for _, p := range peers {
+		if device != "" && p.Device != device {
+			continue
+		}
+		if connected != "" && fmt.Sprint(p.Connected) != connected {
+			continue
+		}
+		filtered = append(filtered, p)
+	}
+	writeJSON(w, http.StatusOK, filtered)
+}
+
+func (s *Server) handlePeer(w http.ResponseWriter, r *http.Request) {
+	_, peers, err := s.tracker.Status()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// peers are looked up by key or by name
+	device, name := r.PathValue("device"), r.PathValue("key")
+	key := NormalizeKey(name)
+	for _, p := range peers {
+		if p.Device == device && (p.PublicKey == key || (p.Name != "" && p.Name == name)) {
+			writeJSON(w, http.StatusOK, p)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("peer %s not found on device %s", name, device))
+}
+
+// NormalizeKey converts URL safe base64 public key to standard encoding
+// used by wireguard tools.
+func NormalizeKey(key string) string {
+	return strings.NewReplacer("-", "+", "_", "/").Replace(key)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to encode api response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
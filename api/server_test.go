@@ -0,0 +1,278 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/turekt/wgmon/history"
+	"github.com/turekt/wgmon/wg"
+)
+
+type trackerMock struct {
+	devices []wg.DeviceStatus
+	peers   []wg.PeerStatus
+}
+
+func (m *trackerMock) Status() ([]wg.DeviceStatus, []wg.PeerStatus, error) {
+	return m.devices, m.peers, nil
+}
+
+func newTrackerMock() *trackerMock {
+	return &trackerMock{
+		devices: []wg.DeviceStatus{
+			{Name: "wg0", Peers: 2, Connected: 1},
+			{Name: "wg1", Peers: 1},
+		},
+		peers: []wg.PeerStatus{
+			{Device: "wg0", PublicKey: "a+b/c=", PeerInfo: wg.PeerInfo{Name: "alice-laptop"}, Connected: true, State: "established"},
+			{Device: "wg0", PublicKey: "def=", State: "inactive"},
+			{Device: "wg1", PublicKey: "ghi=", State: "undefined"},
+		},
+	}
+}
+
+func TestServer(t *testing.T) {
+	srv := httptest.NewServer(NewServer(newTrackerMock(), "secret"))
+	defer srv.Close()
+
+	get := func(path, token string, v any) int {
+		req, err := http.NewRequest("GET", srv.URL+path, nil)
+		if err != nil {
+			t.Fatalf("unable to create request: %v", err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		if v != nil && resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatalf("unable to decode %s: %v", path, err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	if got, want := get("/api/v1/devices", "", nil), http.StatusUnauthorized; got != want {
+		t.Errorf("unexpected status without token: got %d, want %d", got, want)
+	}
+	if got, want := get("/api/v1/devices", "wrong", nil), http.StatusUnauthorized; got != want {
+		t.Errorf("unexpected status with wrong token: got %d, want %d", got, want)
+	}
+
+	var devices []wg.DeviceStatus
+	if got, want := get("/api/v1/devices", "secret", &devices), http.StatusOK; got != want {
+		t.Fatalf("unexpected devices status: got %d, want %d", got, want)
+	}
+	if got, want := len(devices), 2; got != want {
+		t.Errorf("unexpected device count: got %d, want %d", got, want)
+	}
+
+	testCases := []struct {
+		path   string
+		expect int
+	}{
+		{"/api/v1/peers", 3},
+		{"/api/v1/peers?connected=true", 1},
+		{"/api/v1/peers?device=wg1", 1},
+		{"/api/v1/devices/wg0/peers", 2},
+		{"/api/v1/devices/wg2/peers", 0},
+	}
+	for i, tc := range testCases {
+		var peers []wg.PeerStatus
+		if got, want := get(tc.path, "secret", &peers), http.StatusOK; got != want {
+			t.Fatalf("case #%d %s, unexpected status: got %d, want %d", i, tc.path, got, want)
+		}
+		if got, want := len(peers), tc.expect; got != want {
+			t.Errorf("case #%d %s, unexpected peer count: got %d, want %d", i, tc.path, got, want)
+		}
+	}
+
+	for _, key := range []string{url.PathEscape("a+b/c="), "a-b_c=", "alice-laptop"} {
+		var peer wg.PeerStatus
+		if got, want := get("/api/v1/devices/wg0/peers/"+key, "secret", &peer), http.StatusOK; got != want {
+			t.Fatalf("unexpected peer %s status: got %d, want %d", key, got, want)
+		}
+		if got, want := peer.State, "established"; got != want {
+			t.Errorf("unexpected peer %s state: got %s, want %s", key, got, want)
+		}
+	}
+	if got, want := get("/api/v1/devices/wg1/peers/def=", "secret", nil), http.StatusNotFound; got != want {
+		t.Errorf("unexpected missing peer status: got %d, want %d", got, want)
+	}
+}
+
+type healthMock struct {
+	live, ready bool
+}
+
+func (m *healthMock) Liveness() []wg.HealthCheck {
+	return []wg.HealthCheck{{Name: "monitor", OK: true}, {Name: "packets", OK: m.live}}
+}
+
+func (m *healthMock) Readiness() []wg.HealthCheck {
+	return []wg.HealthCheck{{Name: "wgctrl", OK: m.ready}}
+}
+
+func TestHealth(t *testing.T) {
+	mux := http.NewServeMux()
+	HandleHealth(mux, &healthMock{live: true})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	testCases := []struct {
+		path   string
+		expect int
+	}{
+		{"/healthz", http.StatusOK},
+		{"/readyz", http.StatusServiceUnavailable},
+	}
+	for i, tc := range testCases {
+		resp, err := http.Get(srv.URL + tc.path)
+		if err != nil {
+			t.Fatalf("case #%d %s, request failed: %v", i, tc.path, err)
+		}
+		var body healthResponse
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("case #%d %s, unable to decode: %v", i, tc.path, err)
+		}
+		if got, want := resp.StatusCode, tc.expect; got != want {
+			t.Errorf("case #%d %s, unexpected status: got %d, want %d", i, tc.path, got, want)
+		}
+		if got, want := body.Status == "ok", tc.expect == http.StatusOK; got != want {
+			t.Errorf("case #%d %s, unexpected body status %s", i, tc.path, body.Status)
+		}
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	server := NewServer(newTrackerMock(), "secret")
+	server.SetDashboard()
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	testCases := []struct {
+		path   string
+		expect int
+	}{
+		{"/", http.StatusFound},
+		{"/ui/", http.StatusOK},
+		{"/ui/app.js", http.StatusOK},
+		{"/ui/style.css", http.StatusOK},
+		{"/ui/missing.js", http.StatusNotFound},
+		{"/api/v1/devices", http.StatusUnauthorized},
+	}
+	for i, tc := range testCases {
+		resp, err := client.Get(srv.URL + tc.path)
+		if err != nil {
+			t.Fatalf("case #%d %s, request failed: %v", i, tc.path, err)
+		}
+		resp.Body.Close()
+		if got, want := resp.StatusCode, tc.expect; got != want {
+			t.Errorf("case #%d %s, unexpected status: got %d, want %d", i, tc.path, got, want)
+		}
+	}
+}
+
+type historyMock struct {
+	query history.Query
+}
+
+func (m *historyMock) Sessions(q history.Query) ([]history.Session, error) {
+	m.query = q
+	return []history.Session{}, nil
+}
+
+func TestSessionQuery(t *testing.T) {
+	h := &historyMock{}
+	server := NewServer(newTrackerMock(), "")
+	server.SetHistory(h)
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	at := time.Date(2025, 1, 7, 14, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		query  string
+		status int
+		expect history.Query
+	}{
+		{"", http.StatusOK, history.Query{}},
+		{"?peer=alice-laptop&device=wg0", http.StatusOK, history.Query{Peer: "alice-laptop", Device: "wg0"}},
+		{"?peer=xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg%3D", http.StatusOK,
+			history.Query{Peer: "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="}},
+		{"?peer=a-b_c5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=", http.StatusOK,
+			history.Query{Peer: "a+b/c5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="}},
+		{"?at=2025-01-07T14:00:00Z&endpoint=1.2.3.4", http.StatusOK, history.Query{From: at, To: at, Endpoint: "1.2.3.4"}},
+		{"?from=2025-01-07T14:00:00Z&limit=5", http.StatusOK, history.Query{From: at, Limit: 5}},
+		{"?from=yesterday", http.StatusBadRequest, history.Query{}},
+		{"?limit=-1", http.StatusBadRequest, history.Query{}},
+	}
+	for i, tc := range testCases {
+		h.query = history.Query{}
+		resp, err := http.Get(srv.URL + "/api/v1/sessions" + tc.query)
+		if err != nil {
+			t.Fatalf("case #%d, request failed: %v", i, err)
+		}
+		resp.Body.Close()
+		if got, want := resp.StatusCode, tc.status; got != want {
+			t.Errorf("case #%d, unexpected status: got %d, want %d", i, got, want)
+		}
+		if got, want := h.query, tc.expect; got != want {
+			t.Errorf("case #%d, unexpected query: got %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	dir := t.TempDir()
+
+	// stale socket of a previous run is replaced
+	stale := filepath.Join(dir, "stale.sock")
+	l, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	l, err = Listen("unix://" + stale)
+	if err != nil {
+		t.Fatalf("unexpected error replacing stale socket: %v", err)
+	}
+	defer l.Close()
+
+	// socket of a running instance is in use
+	if _, err := Listen("unix://" + stale); !errors.Is(err, syscall.EADDRINUSE) {
+		t.Errorf("unexpected error of live socket: got %v, want %v", err, syscall.EADDRINUSE)
+	}
+
+	// other files are never removed
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen("unix://" + file); err == nil {
+		t.Error("expected error listening on regular file")
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("regular file removed: %v", err)
+	}
+}
@@ -0,0 +1,308 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/turekt/wgmon/hook"
+)
+
+const (
+	StreamDefaultSize = 1024
+
+	// buffered events per subscriber, slower subscribers are disconnected
+	// and expected to resume with last event id
+	subscriberBuffer  = 64
+	keepaliveInterval = 30 * time.Second
+
+	// last event id requesting only new events without backlog
+	noBacklog = ^uint64(0)
+)
+
+// StreamEvent is a tracker event with stream sequence number.
+type StreamEvent struct {
+	ID uint64 `json:"id"`
+	*hook.Event
+}
+
+// EventFilter selects events by device, peer and event type, empty lists
+// match everything.
+type EventFilter struct {
+	Devices []string
+	Peers   []string
+	Types   []string
+}
+
+func NewEventFilter(r *http.Request) EventFilter {
+	query := r.URL.Query()
+	f := EventFilter{
+		Devices: query["device"],
+		Types:   query["type"],
+	}
+	for _, p := range query["peer"] {
+		f.Peers = append(f.Peers, NormalizeKey(p))
+	}
+	return f
+}
+
+func (f *EventFilter) Match(e *hook.Event) bool {
+	matches := func(filter []string, value string) bool {
+		return len(filter) == 0 || slices.Contains(filter, value)
+	}
+	return matches(f.Devices, e.Device) && matches(f.Peers, e.Peer) && matches(f.Types, e.Type.String())
+}
+
+// Subscription receives stream events matching its filter, channel is
+// closed when subscriber falls behind.
+type Subscription struct {
+	filter EventFilter
+	C      chan *StreamEvent
+}
+
+// Stream is a sink keeping last events in a ring buffer and pushing new
+// events to subscribers.
+type Stream struct {
+	mu       sync.Mutex
+	buf      []*StreamEvent
+	next     uint64
+	subs     map[*Subscription]struct{}
+	upgrader websocket.Upgrader
+}
+
+func NewStream(size int) *Stream {
+	if size <= 0 {
+		size = StreamDefaultSize
+	}
+	return &Stream{
+		buf:  make([]*StreamEvent, size),
+		next: 1,
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+func (s *Stream) Name() string {
+	return "stream"
+}
+
+// Accepts every event type including roams, which are delivered only to
+// streams.
+func (s *Stream) Accepts(t hook.EventType) bool {
+	return true
+}
+
+func (s *Stream) Send(e *hook.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	se := &StreamEvent{ID: s.next, Event: e}
+	s.buf[s.next%uint64(len(s.buf))] = se
+	s.next++
+
+	for sub := range s.subs {
+		if !sub.filter.Match(e) {
+			continue
+		}
+		select {
+		case sub.C <- se:
+		default:
+			slog.Warn("dropping slow event stream subscriber", "event", se.ID)
+			delete(s.subs, sub)
+			close(sub.C)
+		}
+	}
+	return nil
+}
+
+// Recent returns buffered events newer than lastID matching filter.
+func (s *Stream) Recent(f EventFilter, lastID uint64) []*StreamEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.recent(f, lastID)
+}
+
+func (s *Stream) recent(f EventFilter, lastID uint64) []*StreamEvent {
+	if lastID == noBacklog {
+		return nil
+	}
+	first := lastID + 1
+	if size := uint64(len(s.buf)); s.next > size && first < s.next-size {
+		first = s.next - size
+	}
+	var events []*StreamEvent
+	for id := first; id < s.next; id++ {
+		if se := s.buf[id%uint64(len(s.buf))]; f.Match(se.Event) {
+			events = append(events, se)
+		}
+	}
+	return events
+}
+
+// Subscribe returns backlog of events after lastID together with a
+// subscription receiving following events. Subscribers not resuming a
+// stream pass noBacklog to receive only new events.
+func (s *Stream) Subscribe(f EventFilter, lastID uint64) ([]*StreamEvent, *Subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub := &Subscription{filter: f, C: make(chan *StreamEvent, subscriberBuffer)}
+	s.subs[sub] = struct{}{}
+	return s.recent(f, lastID), sub
+}
+
+func (s *Stream) Unsubscribe(sub *Subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subs[sub]; ok {
+		delete(s.subs, sub)
+		close(sub.C)
+	}
+}
+
+// HandleRecent lists buffered events as JSON array.
+func (s *Stream) HandleRecent(w http.ResponseWriter, r *http.Request) {
+	lastID, err := lastEventID(r, 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	events := s.Recent(NewEventFilter(r), lastID)
+	if events == nil {
+		events = []*StreamEvent{}
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// HandleSSE streams events as Server-Sent Events. Clients resume by
+// sending Last-Event-ID header, which browsers do automatically.
+func (s *Stream) HandleSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming unsupported"))
+		return
+	}
+	lastID, err := lastEventID(r, noBacklog)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	backlog, sub := s.Subscribe(NewEventFilter(r), lastID)
+	defer s.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(se *StreamEvent) error {
+		data, err := json.Marshal(se)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", se.ID, se.Type, data)
+		return err
+	}
+	for _, se := range backlog {
+		if err := write(se); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case se, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if err := write(se); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// HandleWebSocket streams events as JSON text messages over WebSocket.
+// Clients resume with ?last_event_id= query parameter.
+func (s *Stream) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	lastID, err := lastEventID(r, noBacklog)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	backlog, sub := s.Subscribe(NewEventFilter(r), lastID)
+	defer s.Unsubscribe(sub)
+
+	// reader detects closed connections, client messages are ignored
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, se := range backlog {
+		if err := conn.WriteJSON(se); err != nil {
+			return
+		}
+	}
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case se, ok := <-sub.C:
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"))
+				return
+			}
+			if err := conn.WriteJSON(se); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+func lastEventID(r *http.Request, missing uint64) (uint64, error) {
+	id := r.Header.Get("Last-Event-ID")
+	if id == "" {
+		id = r.URL.Query().Get("last_event_id")
+	}
+	if id == "" {
+		return missing, nil
+	}
+	lastID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid last event id %q", id)
+	}
+	return lastID, nil
+}
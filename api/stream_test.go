@@ -0,0 +1,154 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/turekt/wgmon/hook"
+)
+
+func TestStreamRecent(t *testing.T) {
+	s := NewStream(3)
+	for _, e := range []*hook.Event{
+		hook.NewStateEvent("wg0", "a=", "1.1.1.1:1", "established"),
+		hook.NewStateEvent("wg1", "b=", "2.2.2.2:2", "established"),
+		hook.NewStateEvent("wg0", "a=", "1.1.1.1:1", "closed"),
+		hook.NewRoamEvent("wg0", "a=", "1.1.1.1:1", "3.3.3.3:3"),
+	} {
+		s.Send(e)
+	}
+
+	testCases := []struct {
+		filter EventFilter
+		lastID uint64
+		expect []uint64
+	}{
+		{EventFilter{}, 0, []uint64{2, 3, 4}},
+		{EventFilter{}, 3, []uint64{4}},
+		{EventFilter{}, 4, nil},
+		{EventFilter{}, noBacklog, nil},
+		{EventFilter{Devices: []string{"wg0"}}, 0, []uint64{3, 4}},
+		{EventFilter{Peers: []string{"b="}}, 0, []uint64{2}},
+		{EventFilter{Types: []string{"roam"}}, 0, []uint64{4}},
+	}
+	for i, tc := range testCases {
+		var got []uint64
+		for _, se := range s.Recent(tc.filter, tc.lastID) {
+			got = append(got, se.ID)
+		}
+		if !slices.Equal(got, tc.expect) {
+			t.Errorf("case #%d, unexpected events: got %v, want %v", i, got, tc.expect)
+		}
+	}
+}
+
+func TestStreamSlowSubscriber(t *testing.T) {
+	s := NewStream(0)
+	_, sub := s.Subscribe(EventFilter{}, noBacklog)
+	for i := 0; i <= subscriberBuffer; i++ {
+		s.Send(hook.NewStateEvent("wg0", "a=", "", "established"))
+	}
+
+	n := 0
+	for range sub.C {
+		n++
+	}
+	if got, want := n, subscriberBuffer; got != want {
+		t.Errorf("unexpected buffered events: got %d, want %d", got, want)
+	}
+	// unsubscribing dropped subscriber must not close channel twice
+	s.Unsubscribe(sub)
+}
+
+func TestStreamSSE(t *testing.T) {
+	s := NewStream(0)
+	srv := httptest.NewServer(http.HandlerFunc(s.HandleSSE))
+	defer srv.Close()
+
+	s.Send(hook.NewStateEvent("wg0", "a=", "", "established"))
+	s.Send(hook.NewStateEvent("wg1", "b=", "", "established"))
+
+	req, err := http.NewRequest("GET", srv.URL+"?device=wg0", nil)
+	if err != nil {
+		t.Fatalf("unable to create request: %v", err)
+	}
+	req.Header.Set("Last-Event-ID", "0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("sse request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if got, want := resp.Header.Get("Content-Type"), "text/event-stream"; got != want {
+		t.Fatalf("unexpected content type: got %s, want %s", got, want)
+	}
+
+	// event sent after connecting is delivered live
+	s.Send(hook.NewStateEvent("wg0", "a=", "", "closed"))
+
+	r := bufio.NewReader(resp.Body)
+	for _, want := range []string{"id: 1", "event: state", "", "", "id: 3", "event: state"} {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unable to read sse stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if want == "" {
+			continue
+		}
+		if got := line; got != want {
+			t.Errorf("unexpected sse line: got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestStreamWebSocket(t *testing.T) {
+	s := NewStream(0)
+	srv := httptest.NewServer(http.HandlerFunc(s.HandleWebSocket))
+	defer srv.Close()
+
+	s.Send(hook.NewStateEvent("wg0", "a=", "", "established"))
+	s.Send(hook.NewRoamEvent("wg0", "a=", "1.1.1.1:1", "2.2.2.2:2"))
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "?type=roam&last_event_id=0"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("websocket dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var se StreamEvent
+	if err := conn.ReadJSON(&se); err != nil {
+		t.Fatalf("unable to read websocket event: %v", err)
+	}
+	if got, want := se.ID, uint64(2); got != want {
+		t.Errorf("unexpected event id: got %d, want %d", got, want)
+	}
+	if got, want := se.Previous, "1.1.1.1:1"; got != want {
+		t.Errorf("unexpected previous endpoint: got %s, want %s", got, want)
+	}
+
+	s.Send(hook.NewStateEvent("wg0", "a=", "", "closed"))
+	s.Send(hook.NewRoamEvent("wg0", "a=", "2.2.2.2:2", "3.3.3.3:3"))
+	if err := conn.ReadJSON(&se); err != nil {
+		t.Fatalf("unable to read websocket event: %v", err)
+	}
+	if got, want := se.ID, uint64(4); got != want {
+		t.Errorf("unexpected event id: got %d, want %d", got, want)
+	}
+
+	raw, err := json.Marshal(se)
+	if err != nil {
+		t.Fatalf("unable to marshal event: %v", err)
+	}
+	if !strings.Contains(string(raw), `"type":"roam"`) {
+		t.Errorf("unexpected event encoding: %s", raw)
+	}
+}
@@ -0,0 +1,5 @@
+// Package wgmonpb contains generated protobuf and gRPC bindings of the
+// wgmon Monitor service.
+package wgmonpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative wgmon.proto
@@ -0,0 +1,348 @@
+// Package bandwidth alerts on traffic of peers, tag groups and devices
+// exceeding configured rates or daily and monthly totals. Traffic is
+// computed from peer transfer counters of successive device snapshots.
+package bandwidth
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/turekt/wgmon/hook"
+	"github.com/turekt/wgmon/wg"
+)
+
+// Scopes of traffic groups.
+const (
+	ScopePeer   = "peer"
+	ScopeTag    = "tag"
+	ScopeDevice = "device"
+)
+
+// Directions of traffic compared with limits.
+const (
+	Receive  = "receive"
+	Transmit = "transmit"
+	Total    = "total"
+)
+
+// Periods of traffic totals, both start at midnight of local time.
+const (
+	Day   = "day"
+	Month = "month"
+)
+
+// States of bandwidth events.
+const (
+	Exceeded  = "exceeded"
+	Recovered = "recovered"
+)
+
+var SampleInterval = time.Minute
+
+// Limit is a rate or total traffic limit of every group of Scope, or only
+// of the one named by Match: a peer key or name, a tag or a device.
+type Limit struct {
+	Scope     string
+	Match     string
+	Direction string
+	// Rate in bytes per second averaged over Window, zero when unused
+	Rate   float64
+	Window time.Duration
+	// Total bytes transferred per Period, zero when unused
+	Total  int64
+	Period string
+}
+
+func (l *Limit) String() string {
+	s := l.Scope
+	if l.Match != "" {
+		s += ":" + l.Match
+	}
+	if l.Total > 0 {
+		return fmt.Sprintf("%s %s %d/%s", s, l.Direction, l.Total, l.Period)
+	}
+	return fmt.Sprintf("%s %s %.0f/s/%s", s, l.Direction, l.Rate, l.Window)
+}
+
+// Validate reports the first problem of limit.
+func (l *Limit) Validate() error {
+	switch l.Scope {
+	case ScopePeer, ScopeTag, ScopeDevice:
+	default:
+		return fmt.Errorf("scope must be peer, tag or device, got %q", l.Scope)
+	}
+	switch l.Direction {
+	case Receive, Transmit, Total:
+	default:
+		return fmt.Errorf("direction must be receive, transmit or total, got %q", l.Direction)
+	}
+	switch {
+	case (l.Rate > 0) == (l.Total > 0):
+		return fmt.Errorf("exactly one of rate and total is required")
+	case l.Rate > 0 && l.Window < SampleInterval:
+		return fmt.Errorf("window must be at least %s", SampleInterval)
+	case l.Total > 0 && l.Period != Day && l.Period != Month:
+		return fmt.Errorf("period must be day or month, got %q", l.Period)
+	}
+	return nil
+}
+
+// ParseBytes parses size such as "500", "1.5GB" or "10 MiB", decimal units
+// are powers of 1000 and binary ones powers of 1024.
+func ParseBytes(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return r != '.' && !unicode.IsDigit(r)
+	})
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	unit := strings.ToUpper(strings.TrimSpace(s[i:]))
+	multiplier := float64(1)
+	if unit != "" && unit != "B" {
+		exp := strings.IndexByte("KMGTP", unit[0]) + 1
+		switch {
+		case exp == 0 || len(unit) > 3:
+			return 0, fmt.Errorf("invalid size unit %q", s[i:])
+		case strings.HasSuffix(unit, "IB"):
+			multiplier = math.Pow(1024, float64(exp))
+		case len(unit) == 1 || unit[1:] == "B":
+			multiplier = math.Pow(1000, float64(exp))
+		default:
+			return 0, fmt.Errorf("invalid size unit %q", s[i:])
+		}
+	}
+	return int64(n * multiplier), nil
+}
+
+// sample holds cumulative traffic of a group at a time.
+type sample struct {
+	at       time.Time
+	received int64
+	sent     int64
+}
+
+func (s sample) bytes(direction string) int64 {
+	switch direction {
+	case Receive:
+		return s.received
+	case Transmit:
+		return s.sent
+	}
+	return s.received + s.sent
+}
+
+// group accumulates traffic of peers belonging to it.
+type group struct {
+	scope, name    string
+	device, peer   string
+	peerName       string
+	current        sample
+	samples        []sample
+	periodStart    map[string]sample
+	periodKey      map[string]string
+	exceeded       map[string]bool
+	exceededPeriod map[string]string
+}
+
+// Monitor tracks traffic groups and compares them with limits.
+type Monitor struct {
+	mu       sync.Mutex
+	limits   []Limit
+	counters map[string]sample
+	groups   map[string]*group
+}
+
+func NewMonitor(limits []Limit) *Monitor {
+	return &Monitor{
+		limits:   limits,
+		counters: make(map[string]sample),
+		groups:   make(map[string]*group),
+	}
+}
+
+// SetLimits replaces limits, alert state of unchanged limits is kept.
+// Removing all limits drops collected traffic.
+func (m *Monitor) SetLimits(limits []Limit) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.limits = limits
+	if len(limits) == 0 {
+		clear(m.counters)
+		clear(m.groups)
+	}
+}
+
+// Enabled reports whether there is any limit to check.
+func (m *Monitor) Enabled() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.limits) > 0
+}
+
+// Update adds traffic of peers since the previous update and returns
+// events of limits exceeded or recovered. Counters lower than in the
+// previous update were reset by recreating the device and count from zero.
+func (m *Monitor) Update(peers []wg.PeerStatus, now time.Time) []*hook.Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := make(map[string]bool, len(peers))
+	for _, p := range peers {
+		id := p.Device + ":" + p.PublicKey
+		seen[id] = true
+		counter := sample{at: now, received: p.ReceiveBytes, sent: p.TransmitBytes}
+		prev, ok := m.counters[id]
+		m.counters[id] = counter
+		if !ok {
+			// first snapshot of the peer is the baseline
+			prev = counter
+		}
+		delta := sample{received: counter.received - prev.received, sent: counter.sent - prev.sent}
+		if delta.received < 0 || delta.sent < 0 {
+			delta = sample{received: counter.received, sent: counter.sent}
+		}
+
+		peer, device := m.group(ScopePeer, id), m.group(ScopeDevice, p.Device)
+		peer.device, peer.peer, peer.peerName = p.Device, p.PublicKey, p.Name
+		device.device = p.Device
+		groups := []*group{peer, device}
+		for _, tag := range p.Tags {
+			groups = append(groups, m.group(ScopeTag, tag))
+		}
+		for _, g := range groups {
+			g.current.received += delta.received
+			g.current.sent += delta.sent
+		}
+	}
+	for id := range m.counters {
+		if !seen[id] {
+			delete(m.counters, id)
+		}
+	}
+
+	var keep time.Duration
+	for _, l := range m.limits {
+		keep = max(keep, l.Window)
+	}
+	var events []*hook.Event
+	keys := make([]string, 0, len(m.groups))
+	for key := range m.groups {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		g := m.groups[key]
+		g.current.at = now
+		g.samples = append(g.samples, g.current)
+		// keep the newest sample older than the longest window
+		i := slices.IndexFunc(g.samples, func(s sample) bool {
+			return now.Sub(s.at) < keep
+		})
+		if i < 0 {
+			i = len(g.samples) - 1
+		} else if i > 0 {
+			i--
+		}
+		g.samples = slices.Delete(g.samples, 0, i)
+		for _, l := range m.limits {
+			if l.Scope != g.scope || !g.matches(l.Match) {
+				continue
+			}
+			if e := g.check(l, now); e != nil {
+				events = append(events, e)
+			}
+		}
+	}
+	return events
+}
+
+func (m *Monitor) group(scope, name string) *group {
+	key := scope + ":" + name
+	g, ok := m.groups[key]
+	if !ok {
+		g = &group{
+			scope:          scope,
+			name:           name,
+			periodStart:    make(map[string]sample),
+			periodKey:      make(map[string]string),
+			exceeded:       make(map[string]bool),
+			exceededPeriod: make(map[string]string),
+		}
+		m.groups[key] = g
+	}
+	return g
+}
+
+func (g *group) matches(match string) bool {
+	if match == "" || match == g.name {
+		return true
+	}
+	return g.scope == ScopePeer && (match == g.peer || match == g.peerName)
+}
+
+// check compares group traffic with l and returns event when the limit is
+// crossed. Rates are alerted once when exceeded and once when recovered,
+// totals once per period.
+func (g *group) check(l Limit, now time.Time) *hook.Event {
+	usage := &hook.Usage{Scope: g.scope, Group: g.name, Direction: l.Direction}
+	state := ""
+	id := l.String()
+	if l.Total > 0 {
+		key := now.Format("2006-01-02")
+		if l.Period == Month {
+			key = now.Format("2006-01")
+		}
+		if g.periodKey[l.Period] != key {
+			g.periodKey[l.Period] = key
+			g.periodStart[l.Period] = g.current
+		}
+		used := g.current.bytes(l.Direction) - g.periodStart[l.Period].bytes(l.Direction)
+		if used <= l.Total || g.exceededPeriod[id] == key {
+			return nil
+		}
+		g.exceededPeriod[id] = key
+		state = Exceeded
+		usage.Value, usage.Limit, usage.Period = float64(used), float64(l.Total), l.Period
+	} else {
+		i := slices.IndexFunc(g.samples, func(s sample) bool {
+			return now.Sub(s.at) < l.Window
+		})
+		if i < 1 {
+			// history does not cover the window yet
+			return nil
+		}
+		from := g.samples[i-1]
+		rate := float64(g.current.bytes(l.Direction)-from.bytes(l.Direction)) / now.Sub(from.at).Seconds()
+		switch exceeded := rate > l.Rate; {
+		case exceeded && !g.exceeded[id]:
+			state = Exceeded
+		case !exceeded && g.exceeded[id]:
+			state = Recovered
+		default:
+			return nil
+		}
+		g.exceeded[id] = state == Exceeded
+		usage.Value, usage.Limit, usage.Window = rate, l.Rate, l.Window
+	}
+	var device, peer string
+	switch g.scope {
+	case ScopePeer:
+		device, peer = g.device, g.peer
+	case ScopeDevice:
+		device = g.device
+	}
+	e := hook.NewBandwidthEvent(device, peer, state, usage)
+	e.Time = now
+	return e
+}
@@ -0,0 +1,155 @@
+package bandwidth
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/turekt/wgmon/hook"
+	"github.com/turekt/wgmon/wg"
+)
+
+func TestParseBytes(t *testing.T) {
+	testCases := []struct {
+		value  string
+		expect int64
+		fail   bool
+	}{
+		{"500", 500, false},
+		{"500B", 500, false},
+		{"1.5GB", 1500000000, false},
+		{"10 MiB", 10 << 20, false},
+		{"2k", 2000, false},
+		{"1TiB", 1 << 40, false},
+		{"", 0, true},
+		{"-1MB", 0, true},
+		{"10MX", 0, true},
+		{"10 mibs", 0, true},
+		{"GB", 0, true},
+	}
+	for i, tc := range testCases {
+		got, err := ParseBytes(tc.value)
+		if (err != nil) != tc.fail {
+			t.Errorf("case #%d %q, unexpected error: %v", i, tc.value, err)
+			continue
+		}
+		if got != tc.expect {
+			t.Errorf("case #%d %q, unexpected size: got %d, want %d", i, tc.value, got, tc.expect)
+		}
+	}
+}
+
+func TestLimitValidate(t *testing.T) {
+	testCases := []struct {
+		limit Limit
+		valid bool
+	}{
+		{Limit{Scope: ScopePeer, Direction: Total, Rate: 1000, Window: 5 * time.Minute}, true},
+		{Limit{Scope: ScopeDevice, Direction: Receive, Total: 1000, Period: Month}, true},
+		{Limit{Scope: "host", Direction: Total, Rate: 1000, Window: 5 * time.Minute}, false},
+		{Limit{Scope: ScopeTag, Direction: "both", Rate: 1000, Window: 5 * time.Minute}, false},
+		{Limit{Scope: ScopeTag, Direction: Total}, false},
+		{Limit{Scope: ScopeTag, Direction: Total, Rate: 1000, Total: 1000, Period: Day}, false},
+		{Limit{Scope: ScopeTag, Direction: Total, Rate: 1000, Window: time.Second}, false},
+		{Limit{Scope: ScopeTag, Direction: Total, Total: 1000, Period: "week"}, false},
+	}
+	for i, tc := range testCases {
+		if err := tc.limit.Validate(); (err == nil) != tc.valid {
+			t.Errorf("case #%d %s, unexpected result: %v", i, tc.limit.String(), err)
+		}
+	}
+}
+
+// peers returns status of peer A tagged guest and peer B on wg0 and peer C
+// on wg1 with given cumulative received bytes.
+func peers(a, b, c int64) []wg.PeerStatus {
+	status := func(device, key, name string, received int64, tags ...string) wg.PeerStatus {
+		p := wg.PeerStatus{Device: device, PublicKey: key, ReceiveBytes: received}
+		p.Name, p.Tags = name, tags
+		return p
+	}
+	return []wg.PeerStatus{
+		status("wg0", "A", "alice", a, "guest"),
+		status("wg0", "B", "bob", b, "guest", "staff"),
+		status("wg1", "C", "", c),
+	}
+}
+
+func TestMonitorRate(t *testing.T) {
+	start := time.Date(2024, 5, 14, 9, 0, 0, 0, time.Local)
+	m := NewMonitor([]Limit{
+		{Scope: ScopePeer, Match: "alice", Direction: Receive, Rate: 1000, Window: 2 * time.Minute},
+		{Scope: ScopeTag, Direction: Total, Rate: 1500, Window: 2 * time.Minute},
+		{Scope: ScopeDevice, Match: "wg1", Direction: Transmit, Rate: 1, Window: 2 * time.Minute},
+	})
+
+	testCases := []struct {
+		a, b, c int64
+		expect  []string
+	}{
+		{0, 0, 0, nil},
+		{60000, 0, 0, nil},
+		// 1000 B/s of alice, 1000 B/s of guests
+		{120000, 60000, 1000000, nil},
+		// 1500 B/s of alice, 1500 B/s of guests including bob
+		{240000, 60000, 2000000, []string{"peer wg0:A exceeded", "tag guest exceeded"}},
+		// alerted only once while exceeded
+		{330000, 240000, 3000000, nil},
+		// counters of wg1 reset by recreating the device
+		{340000, 250000, 0, []string{"peer wg0:A recovered", "tag staff exceeded"}},
+		{340000, 250000, 0, []string{"tag guest recovered", "tag staff recovered"}},
+	}
+	for i, tc := range testCases {
+		var got []string
+		for _, e := range m.Update(peers(tc.a, tc.b, tc.c), start.Add(time.Duration(i)*time.Minute)) {
+			got = append(got, e.Bandwidth.Scope+" "+e.Bandwidth.Group+" "+e.State)
+			if e.Type != hook.EventBandwidth {
+				t.Errorf("case #%d, unexpected type: %v", i, e.Type)
+			}
+		}
+		if !slices.Equal(got, tc.expect) {
+			t.Errorf("case #%d, unexpected events: got %v, want %v", i, got, tc.expect)
+		}
+	}
+}
+
+func TestMonitorTotal(t *testing.T) {
+	start := time.Date(2024, 5, 31, 21, 0, 0, 0, time.Local)
+	m := NewMonitor([]Limit{
+		{Scope: ScopeDevice, Direction: Receive, Total: 1000, Period: Day},
+		{Scope: ScopePeer, Match: "C", Direction: Receive, Total: 1500, Period: Month},
+	})
+
+	testCases := []struct {
+		hours  int
+		c      int64
+		expect []string
+	}{
+		{0, 100000, nil},
+		{1, 100800, nil},
+		{2, 101200, []string{"device wg1 exceeded 1200/day"}},
+		// next day and month, counters reset by recreating the device
+		{3, 500, nil},
+		{4, 1100, nil},
+		{5, 2000, []string{"device wg1 exceeded 1500/day"}},
+		// alerted once per period
+		{6, 2100, []string{"peer wg1:C exceeded 1600/month"}},
+		{7, 5000, nil},
+	}
+	for i, tc := range testCases {
+		var got []string
+		for _, e := range m.Update(peers(0, 0, tc.c), start.Add(time.Duration(tc.hours)*time.Hour)) {
+			u := e.Bandwidth
+			got = append(got, fmt.Sprintf("%s %s %s %.0f/%s", u.Scope, u.Group, e.State, u.Value, u.Period))
+		}
+		if !slices.Equal(got, tc.expect) {
+			t.Errorf("case #%d, unexpected events: got %v, want %v", i, got, tc.expect)
+		}
+	}
+
+	m.SetLimits(nil)
+	if m.Enabled() {
+		t.Error("monitor without limits should be disabled")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/turekt/wgmon/ctl"
+	"github.com/turekt/wgmon/wg"
+)
+
+const usage = `Usage: wgmon [command] [flags]
+
+Commands:
+  run          run the monitoring daemon (default)
+  doctor       check monitoring setup using the daemon flags and suggest fixes
+  status       show devices and peers with connection state
+  peers        list peers as a table
+  history      list recent events
+  sessions     query recorded sessions, e.g. who was connected at a given time
+  notify-test  send a test notification through all configured sinks
+
+Run 'wgmon <command> -h' for command flags.
+`
+
+func runClient(cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	socket := os.Getenv("control")
+	if socket == "" {
+		socket = ctl.DefaultSocket
+	}
+	socketPtr := fs.String("socket", socket, "control socket of running daemon, or http(s):// url of its api")
+	tokenPtr := fs.String("token", os.Getenv("api_token"), "api token, when connecting over http(s)")
+	jsonPtr := fs.Bool("json", false, "print json instead of tables")
+	var devicePtr, peerPtr, typePtr, endpointPtr, fromPtr, toPtr, atPtr, limitPtr *string
+	var connectedPtr *bool
+	switch cmd {
+	case "status", "peers":
+		devicePtr = fs.String("device", "", "show only peers of device")
+		connectedPtr = fs.Bool("connected", false, "show only connected peers")
+	case "history":
+		devicePtr = fs.String("device", "", "show only events of device")
+		peerPtr = fs.String("peer", "", "show only events of peer public key")
+		typePtr = fs.String("type", "", "show only events of type, e.g. state")
+	case "sessions":
+		devicePtr = fs.String("device", "", "show only sessions of device")
+		peerPtr = fs.String("peer", "", "show only sessions of peer public key or name")
+		endpointPtr = fs.String("endpoint", "", "show only sessions from endpoint address or IP")
+		fromPtr = fs.String("from", "", "show sessions active after time, e.g. 2024-05-14 or \"2024-05-14 14:00\"")
+		toPtr = fs.String("to", "", "show sessions active before time")
+		atPtr = fs.String("at", "", "show sessions active at time")
+		limitPtr = fs.String("limit", "", "maximum number of sessions, default 100")
+	}
+	fs.Parse(args)
+
+	client := ctl.NewClient(*socketPtr, *tokenPtr)
+	now := time.Now()
+	out := os.Stdout
+	switch cmd {
+	case "status", "peers":
+		peers, err := client.Peers(*devicePtr)
+		if err != nil {
+			return err
+		}
+		if *connectedPtr {
+			var connected []wg.PeerStatus
+			for _, p := range peers {
+				if p.Connected {
+					connected = append(connected, p)
+				}
+			}
+			peers = connected
+		}
+		if cmd == "peers" {
+			if *jsonPtr {
+				return ctl.PrintJSON(out, peers)
+			}
+			ctl.PrintPeers(out, peers, now)
+			return nil
+		}
+
+		devices, err := client.Devices()
+		if err != nil {
+			return err
+		}
+		if *devicePtr != "" {
+			var filtered []wg.DeviceStatus
+			for _, d := range devices {
+				if d.Name == *devicePtr {
+					filtered = append(filtered, d)
+				}
+			}
+			devices = filtered
+		}
+		if *jsonPtr {
+			return ctl.PrintJSON(out, map[string]any{"devices": devices, "peers": peers})
+		}
+		ctl.PrintStatus(out, devices, peers, now)
+	case "history":
+		events, err := client.Events(url.Values{
+			"device": {*devicePtr},
+			"peer":   {*peerPtr},
+			"type":   {*typePtr},
+		})
+		if err != nil {
+			return err
+		}
+		if *jsonPtr {
+			return ctl.PrintJSON(out, events)
+		}
+		ctl.PrintEvents(out, events)
+	case "sessions":
+		query := url.Values{
+			"device":   {*devicePtr},
+			"peer":     {*peerPtr},
+			"endpoint": {*endpointPtr},
+			"limit":    {*limitPtr},
+		}
+		for name, value := range map[string]string{"from": *fromPtr, "to": *toPtr, "at": *atPtr} {
+			t, err := parseTime(value)
+			if err != nil {
+				return fmt.Errorf("invalid -%s: %w", name, err)
+			}
+			if !t.IsZero() {
+				query.Set(name, t.Format(time.RFC3339))
+			}
+		}
+		sessions, err := client.Sessions(query)
+		if err != nil {
+			return err
+		}
+		if *jsonPtr {
+			return ctl.PrintJSON(out, sessions)
+		}
+		ctl.PrintSessions(out, sessions, now)
+	case "notify-test":
+		results, err := client.NotifyTest()
+		if err != nil {
+			return err
+		}
+		if *jsonPtr {
+			return ctl.PrintJSON(out, results)
+		}
+		if !ctl.PrintResults(out, results) {
+			return errors.New("test notification failed")
+		}
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+	return nil
+}
+
+// parseTime parses RFC 3339 time or local date with optional time of day.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{time.DateTime, "2006-01-02 15:04", "2006-01-02T15:04", time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q, use RFC 3339 or \"2006-01-02 15:04\"", value)
+}
@@ -0,0 +1,613 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/turekt/wgmon/anomaly"
+	"github.com/turekt/wgmon/bandwidth"
+	"github.com/turekt/wgmon/history"
+	"github.com/turekt/wgmon/hook"
+	"github.com/turekt/wgmon/inventory"
+	"github.com/turekt/wgmon/network"
+	"github.com/turekt/wgmon/wg"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is a time.Duration written in configuration as "30s" or "10m".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration %q", value.Line, s)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalYAML() (any, error) {
+	return time.Duration(d).String(), nil
+}
+
+// Size is a number of bytes written in configuration as "500MB" or
+// "1GiB", rates may be suffixed with "/s".
+type Size int64
+
+func (s *Size) UnmarshalYAML(value *yaml.Node) error {
+	var str string
+	if err := value.Decode(&str); err != nil {
+		return err
+	}
+	n, err := bandwidth.ParseBytes(strings.TrimSuffix(str, "/s"))
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+	*s = Size(n)
+	return nil
+}
+
+func (s Size) MarshalYAML() (any, error) {
+	return strconv.FormatInt(int64(s), 10), nil
+}
+
+// Config is the complete wgmon configuration, either loaded from a YAML
+// file or assembled from flags and environment variables.
+type Config struct {
+	Monitor   Monitor          `yaml:"monitor"`
+	Devices   []string         `yaml:"devices"`
+	Filter    Filter           `yaml:"filter"`
+	Peers     []Peer           `yaml:"peers"`
+	Inventory Inventory        `yaml:"inventory"`
+	GeoIP     []string         `yaml:"geoip"`
+	Sinks     Sinks            `yaml:"sinks"`
+	Flap      Flap             `yaml:"flap"`
+	Attempts  Attempts         `yaml:"attempts"`
+	Anomaly   Anomaly          `yaml:"anomaly"`
+	Bandwidth []BandwidthLimit `yaml:"bandwidth"`
+	Health    Health           `yaml:"health"`
+	History   History          `yaml:"history"`
+	State     string           `yaml:"state"`
+	Metrics   string           `yaml:"metrics"`
+	API       API              `yaml:"api"`
+	GRPC      string           `yaml:"grpc"`
+	Control   string           `yaml:"control"`
+}
+
+type Monitor struct {
+	Type        string   `yaml:"type"`
+	Group       uint16   `yaml:"group"`
+	Interface   string   `yaml:"interface"`
+	Filter      string   `yaml:"filter"`
+	Interval    Duration `yaml:"interval"`
+	MaxInterval Duration `yaml:"max_interval"`
+}
+
+// Filter excludes devices and selects peers tracked on included devices,
+// empty lists select everything. Excludes take precedence over includes.
+type Filter struct {
+	ExcludeDevices    []string `yaml:"exclude_devices"`
+	Peers             []string `yaml:"peers"`
+	ExcludePeers      []string `yaml:"exclude_peers"`
+	Tags              []string `yaml:"tags"`
+	ExcludeTags       []string `yaml:"exclude_tags"`
+	AllowedIPs        []string `yaml:"allowed_ips"`
+	ExcludeAllowedIPs []string `yaml:"exclude_allowed_ips"`
+}
+
+// Peer is metadata of a peer, taking precedence over the inventory.
+type Peer = inventory.Entry
+
+// Inventory locates peer metadata files reloaded whenever they change.
+type Inventory struct {
+	WireGuard string `yaml:"wireguard"`
+	File      string `yaml:"file"`
+}
+
+type Sinks struct {
+	Webhook  string `yaml:"webhook"`
+	Exec     Exec   `yaml:"exec"`
+	Syslog   string `yaml:"syslog"`
+	Journald bool   `yaml:"journald"`
+	MQTT     string `yaml:"mqtt"`
+	SMTP     SMTP   `yaml:"smtp"`
+	Digest   Digest `yaml:"digest"`
+}
+
+type Exec struct {
+	Command     string   `yaml:"command"`
+	Timeout     Duration `yaml:"timeout"`
+	Concurrency int      `yaml:"concurrency"`
+}
+
+type SMTP struct {
+	Server string   `yaml:"server"`
+	To     []string `yaml:"to"`
+}
+
+type Digest struct {
+	Window   Duration `yaml:"window"`
+	Max      int      `yaml:"max"`
+	Priority []string `yaml:"priority"`
+}
+
+type Flap struct {
+	Threshold int      `yaml:"threshold"`
+	Window    Duration `yaml:"window"`
+	Cooldown  Duration `yaml:"cooldown"`
+}
+
+// Attempts configures reporting of connection attempts never completing a
+// handshake.
+type Attempts struct {
+	Interval Duration `yaml:"interval"`
+}
+
+// Anomaly enables alerts on connections deviating from peer history, each
+// rule is disabled by its zero value.
+type Anomaly struct {
+	Location    []string `yaml:"location"`
+	Concurrent  Duration `yaml:"concurrent"`
+	Hours       float64  `yaml:"hours"`
+	MinSessions int      `yaml:"min_sessions"`
+}
+
+// BandwidthLimit alerts when traffic of a peer, tag group or device
+// exceeds rate averaged over window or total per period.
+type BandwidthLimit struct {
+	Scope     string   `yaml:"scope"`
+	Match     string   `yaml:"match"`
+	Direction string   `yaml:"direction"`
+	Rate      Size     `yaml:"rate"`
+	Window    Duration `yaml:"window"`
+	Total     Size     `yaml:"total"`
+	Period    string   `yaml:"period"`
+}
+
+type Health struct {
+	Listen        string   `yaml:"listen"`
+	PacketTimeout Duration `yaml:"packet_timeout"`
+	MaxPending    int      `yaml:"max_pending"`
+}
+
+type History struct {
+	Path      string   `yaml:"path"`
+	Retention Duration `yaml:"retention"`
+	Max       int      `yaml:"max"`
+}
+
+type API struct {
+	Listen    string `yaml:"listen"`
+	Token     string `yaml:"token"`
+	Cert      string `yaml:"cert"`
+	Key       string `yaml:"key"`
+	ClientCA  string `yaml:"client_ca"`
+	Dashboard bool   `yaml:"dashboard"`
+}
+
+// Default returns configuration used for values missing in file or flags.
+func Default() *Config {
+	return &Config{
+		Monitor: Monitor{
+			Type:        "nflog",
+			Group:       1,
+			Interface:   "eth0",
+			Filter:      "udp and dst port 3000",
+			Interval:    Duration(network.PollDefaultInterval),
+			MaxInterval: Duration(network.PollDefaultMaxInterval),
+		},
+		Sinks: Sinks{
+			Exec: Exec{
+				Timeout:     Duration(hook.ExecDefaultTimeout),
+				Concurrency: hook.ExecDefaultConcurrency,
+			},
+			Digest: Digest{Max: hook.DigestDefaultMax},
+		},
+		Inventory: Inventory{WireGuard: "/etc/wireguard/*.conf"},
+		Flap:      Flap{Window: Duration(10 * time.Minute)},
+		Attempts:  Attempts{Interval: Duration(wg.AttemptsDefaultInterval)},
+		Anomaly:   Anomaly{MinSessions: anomaly.DefaultMinSessions},
+		Health: Health{
+			PacketTimeout: Duration(wg.HealthDefaultPacketTimeout),
+			MaxPending:    wg.HealthDefaultMaxPending,
+		},
+		History: History{Retention: Duration(history.DefaultRetention)},
+		Control: "/run/wgmon.sock",
+	}
+}
+
+// Load reads and validates configuration file. Unknown keys are rejected
+// so typos do not silently fall back to defaults.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	c, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Parse decodes YAML configuration on top of defaults and validates it.
+func Parse(data []byte) (*Config, error) {
+	c := Default()
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate reports all configuration problems at once, each prefixed with
+// the path of the offending setting.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(path, format string, a ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, a...)))
+	}
+
+	switch c.Monitor.Type {
+	case "nflog":
+	case "bpf":
+		if c.Monitor.Interface == "" {
+			fail("monitor.interface", "required by bpf monitor")
+		}
+		if c.Monitor.Filter == "" {
+			fail("monitor.filter", "required by bpf monitor")
+		}
+	case "poll":
+		if c.Monitor.Interval <= 0 {
+			fail("monitor.interval", "must be positive for poll monitor")
+		}
+		if c.Monitor.MaxInterval < c.Monitor.Interval {
+			fail("monitor.max_interval", "must not be shorter than monitor.interval")
+		}
+	default:
+		fail("monitor.type", "must be nflog, bpf or poll, got %q", c.Monitor.Type)
+	}
+
+	globs := func(path string, patterns []string) {
+		for i, p := range patterns {
+			if strings.TrimSpace(p) == "" {
+				fail(fmt.Sprintf("%s[%d]", path, i), "empty device name")
+			} else if _, err := filepath.Match(p, ""); err != nil {
+				fail(fmt.Sprintf("%s[%d]", path, i), "invalid pattern %q", p)
+			}
+		}
+	}
+	keys := func(path string, keys []string) {
+		for i, k := range keys {
+			if _, err := wgtypes.ParseKey(k); err != nil {
+				fail(fmt.Sprintf("%s[%d]", path, i), "invalid public key %q", k)
+			}
+		}
+	}
+	prefixes := func(path string, prefixes []string) {
+		for i, p := range prefixes {
+			if _, err := netip.ParsePrefix(p); err != nil {
+				fail(fmt.Sprintf("%s[%d]", path, i), "invalid cidr %q", p)
+			}
+		}
+	}
+	globs("devices", c.Devices)
+	globs("filter.exclude_devices", c.Filter.ExcludeDevices)
+	keys("filter.peers", c.Filter.Peers)
+	keys("filter.exclude_peers", c.Filter.ExcludePeers)
+	prefixes("filter.allowed_ips", c.Filter.AllowedIPs)
+	prefixes("filter.exclude_allowed_ips", c.Filter.ExcludeAllowedIPs)
+	seen := make(map[string]bool)
+	for i, p := range c.Peers {
+		path := fmt.Sprintf("peers[%d]", i)
+		if _, err := wgtypes.ParseKey(p.PublicKey); err != nil {
+			fail(path+".public_key", "invalid public key %q", p.PublicKey)
+			continue
+		}
+		if id := p.ID(); seen[id] {
+			fail(path+".public_key", "duplicate peer %s", id)
+		} else {
+			seen[id] = true
+		}
+	}
+
+	for i, path := range c.GeoIP {
+		if strings.TrimSpace(path) == "" {
+			fail(fmt.Sprintf("geoip[%d]", i), "empty database path")
+		}
+	}
+	if _, err := filepath.Match(c.Inventory.WireGuard, ""); err != nil {
+		fail("inventory.wireguard", "invalid pattern %q", c.Inventory.WireGuard)
+	}
+
+	s := c.Sinks
+	if s.Exec.Timeout < 0 {
+		fail("sinks.exec.timeout", "must not be negative")
+	}
+	if s.Exec.Concurrency < 0 {
+		fail("sinks.exec.concurrency", "must not be negative")
+	}
+	if s.SMTP.Server != "" || len(s.SMTP.To) > 0 {
+		if s.SMTP.Server == "" {
+			fail("sinks.smtp.server", "required when recipients are set")
+		}
+		if _, err := hook.ParseMailRoutes(strings.Join(s.SMTP.To, ",")); err != nil {
+			fail("sinks.smtp.to", "%v", err)
+		}
+	}
+	if s.Digest.Window < 0 {
+		fail("sinks.digest.window", "must not be negative")
+	}
+	if s.Digest.Max < 0 {
+		fail("sinks.digest.max", "must not be negative")
+	}
+
+	if c.Flap.Threshold < 0 {
+		fail("flap.threshold", "must not be negative")
+	}
+	if c.Flap.Threshold > 0 && c.Flap.Window <= 0 {
+		fail("flap.window", "must be positive when flap detection is enabled")
+	}
+	if c.Flap.Cooldown < 0 {
+		fail("flap.cooldown", "must not be negative")
+	}
+	if c.Attempts.Interval < 0 {
+		fail("attempts.interval", "must not be negative")
+	}
+	for i, by := range c.Anomaly.Location {
+		path := fmt.Sprintf("anomaly.location[%d]", i)
+		switch by {
+		case anomaly.ByNetwork:
+		case anomaly.ByCountry, anomaly.ByASN:
+			if len(c.GeoIP) == 0 {
+				fail(path, "%s requires geoip", by)
+			}
+		default:
+			fail(path, "must be country, asn or network, got %q", by)
+		}
+	}
+	if len(c.Anomaly.Location) > 0 && c.History.Path == "" {
+		fail("anomaly.location", "requires history.path")
+	}
+	if c.Anomaly.Concurrent < 0 {
+		fail("anomaly.concurrent", "must not be negative")
+	}
+	if c.Anomaly.Hours < 0 || c.Anomaly.Hours >= 1 {
+		fail("anomaly.hours", "must be a fraction between 0 and 1, got %v", c.Anomaly.Hours)
+	}
+	if c.Anomaly.Hours > 0 && c.History.Path == "" {
+		fail("anomaly.hours", "requires history.path")
+	}
+	if c.Anomaly.MinSessions < 0 {
+		fail("anomaly.min_sessions", "must not be negative")
+	}
+
+	for i, l := range c.BandwidthLimits() {
+		if err := l.Validate(); err != nil {
+			fail(fmt.Sprintf("bandwidth[%d]", i), "%v", err)
+		}
+	}
+
+	if c.Health.PacketTimeout < 0 {
+		fail("health.packet_timeout", "must not be negative")
+	}
+	if c.Health.MaxPending < 0 {
+		fail("health.max_pending", "must not be negative")
+	}
+
+	if c.History.Retention < 0 {
+		fail("history.retention", "must not be negative")
+	}
+	if c.History.Max < 0 {
+		fail("history.max", "must not be negative")
+	}
+
+	if (c.API.Cert == "") != (c.API.Key == "") {
+		fail("api.cert", "cert and key must be set together")
+	}
+	if c.API.ClientCA != "" && c.API.Cert == "" {
+		fail("api.client_ca", "requires api.cert and api.key")
+	}
+	if c.API.Dashboard && c.API.Listen == "" {
+		fail("api.dashboard", "requires api.listen")
+	}
+	return errors.Join(errs...)
+}
+
+// NewMonitor creates packet monitor described by configuration.
+func (m *Monitor) NewMonitor() network.Monitor {
+	switch m.Type {
+	case "bpf":
+		return network.NewBPFMonitor(m.Interface, m.Filter)
+	case "poll":
+		return network.NewPollMonitor(time.Duration(m.Interval), time.Duration(m.MaxInterval))
+	}
+	return network.NewNFLogMonitor(m.Group, 0)
+}
+
+// NewSinks creates configured event sinks, webhook and email are wrapped
+// with a digest when enabled.
+func (s *Sinks) NewSinks() ([]hook.Sink, error) {
+	// message oriented sinks get rate limited, aggregate their events if requested
+	withDigest := func(sink hook.Sink) hook.Sink {
+		if s.Digest.Window <= 0 {
+			return sink
+		}
+		return hook.NewDigestSink(sink, time.Duration(s.Digest.Window), s.Digest.Max, s.Digest.Priority)
+	}
+
+	var sinks []hook.Sink
+	if s.Webhook != "" {
+		sinks = append(sinks, withDigest(hook.NewWebhookSink(s.Webhook)))
+	}
+	if s.Exec.Command != "" {
+		sinks = append(sinks, hook.NewExecSink(s.Exec.Command, time.Duration(s.Exec.Timeout), s.Exec.Concurrency))
+	}
+	if s.Syslog != "" {
+		sink, err := hook.NewSyslogSink(s.Syslog)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initiate syslog: %w", err)
+		}
+		sinks = append(sinks, sink)
+	}
+	if s.Journald {
+		sinks = append(sinks, hook.NewJournaldSink(""))
+	}
+	if s.MQTT != "" {
+		sink, err := hook.NewMQTTSink(s.MQTT)
+		if err != nil {
+			CloseSinks(sinks)
+			return nil, fmt.Errorf("failed to initiate mqtt: %w", err)
+		}
+		sinks = append(sinks, sink)
+	}
+	if s.SMTP.Server != "" {
+		sink, err := hook.NewMailSink(s.SMTP.Server, strings.Join(s.SMTP.To, ","))
+		if err != nil {
+			CloseSinks(sinks)
+			return nil, fmt.Errorf("failed to initiate smtp: %w", err)
+		}
+		sinks = append(sinks, withDigest(sink))
+	}
+	return sinks, nil
+}
+
+// CloseSinks releases sinks holding connections open.
+func CloseSinks(sinks []hook.Sink) {
+	for _, s := range sinks {
+		if c, ok := s.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				slog.Error("sink close error", "sink", s.Name(), "error", err)
+			}
+		}
+	}
+}
+
+// NewFlapDetector creates flap detector, nil when detection and cooldown
+// are disabled.
+func (f *Flap) NewFlapDetector() *wg.FlapDetector {
+	return wg.NewFlapDetector(f.Threshold, time.Duration(f.Window), time.Duration(f.Cooldown))
+}
+
+// NewFilter returns selection of tracked devices and peers, nil when
+// everything is tracked. Configuration must be valid.
+func (c *Config) NewFilter() *wg.Filter {
+	f := c.Filter
+	if len(c.Devices) == 0 && reflect.ValueOf(f).IsZero() {
+		return nil
+	}
+	prefixes := func(cidrs []string) []netip.Prefix {
+		var prefixes []netip.Prefix
+		for _, cidr := range cidrs {
+			if p, err := netip.ParsePrefix(cidr); err == nil {
+				prefixes = append(prefixes, p.Masked())
+			}
+		}
+		return prefixes
+	}
+	return &wg.Filter{
+		Devices:           c.Devices,
+		ExcludeDevices:    f.ExcludeDevices,
+		Peers:             f.Peers,
+		ExcludePeers:      f.ExcludePeers,
+		Tags:              f.Tags,
+		ExcludeTags:       f.ExcludeTags,
+		AllowedIPs:        prefixes(f.AllowedIPs),
+		ExcludeAllowedIPs: prefixes(f.ExcludeAllowedIPs),
+	}
+}
+
+// Rules returns anomaly detection rules.
+func (a *Anomaly) Rules() anomaly.Rules {
+	return anomaly.Rules{
+		Location:    a.Location,
+		Concurrent:  time.Duration(a.Concurrent),
+		Hours:       a.Hours,
+		MinSessions: a.MinSessions,
+	}
+}
+
+// BandwidthLimits returns configured bandwidth limits, direction defaults
+// to total traffic.
+func (c *Config) BandwidthLimits() []bandwidth.Limit {
+	limits := make([]bandwidth.Limit, 0, len(c.Bandwidth))
+	for _, l := range c.Bandwidth {
+		if l.Direction == "" {
+			l.Direction = bandwidth.Total
+		}
+		limits = append(limits, bandwidth.Limit{
+			Scope:     l.Scope,
+			Match:     l.Match,
+			Direction: l.Direction,
+			Rate:      float64(l.Rate),
+			Window:    time.Duration(l.Window),
+			Total:     int64(l.Total),
+			Period:    l.Period,
+		})
+	}
+	return limits
+}
+
+// ParseBandwidth parses comma separated bandwidth limits given as scope,
+// optional match and query style settings, e.g.
+// peer?rate=10MB/s&window=5m,device:wg0?total=1TB&period=month
+func ParseBandwidth(spec string) ([]BandwidthLimit, error) {
+	var limits []BandwidthLimit
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		target, rawQuery, _ := strings.Cut(entry, "?")
+		query, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bandwidth limit %q: %w", entry, err)
+		}
+		l := BandwidthLimit{Direction: query.Get("direction"), Period: query.Get("period")}
+		l.Scope, l.Match, _ = strings.Cut(target, ":")
+		for key, value := range map[string]*Size{"rate": &l.Rate, "total": &l.Total} {
+			if v := query.Get(key); v != "" {
+				n, err := bandwidth.ParseBytes(strings.TrimSuffix(v, "/s"))
+				if err != nil {
+					return nil, fmt.Errorf("invalid bandwidth limit %q: %w", entry, err)
+				}
+				*value = Size(n)
+			}
+		}
+		if v := query.Get("window"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid bandwidth limit %q: invalid window %q", entry, v)
+			}
+			l.Window = Duration(d)
+		}
+		limits = append(limits, l)
+	}
+	return limits, nil
+}
+
+// NewInventory creates inventory of peer metadata with configured peers
+// taking precedence over files.
+func (c *Config) NewInventory() *inventory.Inventory {
+	return inventory.New(c.Inventory.WireGuard, c.Inventory.File, c.Peers)
+}
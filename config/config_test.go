@@ -0,0 +1,143 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/turekt/wgmon/inventory"
+)
+
+const testKey = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(`
+monitor:
+  type: bpf
+  interface: wg-host
+devices: [wg0]
+peers:
+  - public_key: ` + testKey + `
+    device: wg0
+    name: alice
+    tags: [laptop]
+sinks:
+  exec:
+    command: echo
+  digest:
+    window: 30s
+flap:
+  threshold: 6
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.Monitor.Interface, "wg-host"; got != want {
+		t.Errorf("unexpected interface: got %v, want %v", got, want)
+	}
+	// defaults are kept for missing keys
+	if got, want := c.Monitor.Filter, "udp and dst port 3000"; got != want {
+		t.Errorf("unexpected filter: got %v, want %v", got, want)
+	}
+	if got, want := time.Duration(c.Sinks.Exec.Timeout), 30*time.Second; got != want {
+		t.Errorf("unexpected exec timeout: got %v, want %v", got, want)
+	}
+	if got, want := time.Duration(c.Sinks.Digest.Window), 30*time.Second; got != want {
+		t.Errorf("unexpected digest window: got %v, want %v", got, want)
+	}
+	if got, want := time.Duration(c.Flap.Window), 10*time.Minute; got != want {
+		t.Errorf("unexpected flap window: got %v, want %v", got, want)
+	}
+	peers := inventory.Merge(c.Peers)
+	info, ok := peers["wg0:"+testKey]
+	if !ok {
+		t.Fatalf("peer info missing: %v", peers)
+	}
+	if got, want := info.Name, "alice"; got != want {
+		t.Errorf("unexpected peer name: got %v, want %v", got, want)
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	c, err := Parse(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.Monitor.Type, "nflog"; got != want {
+		t.Errorf("unexpected monitor: got %v, want %v", got, want)
+	}
+}
+
+func TestParseBandwidth(t *testing.T) {
+	flag, err := ParseBandwidth("peer:alice?rate=10MB/s&window=5m&direction=receive, device?total=1TiB&period=month")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Parse([]byte(`
+bandwidth:
+  - scope: peer
+    match: alice
+    direction: receive
+    rate: 10MB/s
+    window: 5m
+  - scope: device
+    total: 1TiB
+    period: month
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flag, c.Bandwidth) {
+		t.Errorf("flag and file limits differ: %+v, %+v", flag, c.Bandwidth)
+	}
+	limits := c.BandwidthLimits()
+	if got, want := limits[0].Rate, 10e6; got != want {
+		t.Errorf("unexpected rate: got %v, want %v", got, want)
+	}
+	if got, want := limits[1].Direction, "total"; got != want {
+		t.Errorf("unexpected default direction: got %v, want %v", got, want)
+	}
+
+	for i, spec := range []string{"peer?rate=10QB", "peer?window=5", "peer?rate=%zz"} {
+		if _, err := ParseBandwidth(spec); err == nil {
+			t.Errorf("case #%d %s, expected error", i, spec)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	testCases := []struct {
+		data   string
+		expect []string
+	}{
+		{"webhok: http://localhost", []string{"webhok"}},
+		{"flap:\n  window: 10", []string{"invalid duration"}},
+		{"monitor:\n  type: pcap", []string{"monitor.type"}},
+		{"monitor:\n  type: poll\n  interval: 1m\n  max_interval: 30s", []string{"monitor.max_interval"}},
+		{"peers:\n  - public_key: abc", []string{"peers[0].public_key"}},
+		{"peers:\n  - public_key: " + testKey + "\n  - public_key: " + testKey, []string{"duplicate peer"}},
+		{"inventory:\n  wireguard: /etc/wireguard/[*.conf", []string{"inventory.wireguard"}},
+		{"sinks:\n  smtp:\n    to: [ops@example.com]", []string{"sinks.smtp.server"}},
+		{"api:\n  cert: cert.pem\n  dashboard: true", []string{"api.cert", "api.dashboard"}},
+		{"flap:\n  threshold: -1\nhealth:\n  max_pending: -1", []string{"flap.threshold", "health.max_pending"}},
+		{"attempts:\n  interval: -1m", []string{"attempts.interval"}},
+		{"anomaly:\n  location: [country, city]\n  hours: 0.1", []string{"anomaly.location[0]", "anomaly.location[1]", "anomaly.location:", "anomaly.hours"}},
+		{"bandwidth:\n  - scope: peer\n    rate: 10XB", []string{"invalid size unit"}},
+		{"bandwidth:\n  - scope: host\n    rate: 10MB/s\n    window: 5m\n  - scope: tag\n    total: 1GB", []string{"bandwidth[0]", "bandwidth[1]"}},
+		{"devices: [\"wg[0\"]\nfilter:\n  peers: [abc]\n  exclude_allowed_ips: [10.0.0.0/33]", []string{"devices[0]", "filter.peers[0]", "filter.exclude_allowed_ips[0]"}},
+		{"history:\n  path: h.db\nanomaly:\n  hours: 1.5\n  concurrent: -1m", []string{"anomaly.hours", "anomaly.concurrent"}},
+	}
+	for i, tc := range testCases {
+		_, err := Parse([]byte(tc.data))
+		if err == nil {
+			t.Errorf("case #%d, expected error", i)
+			continue
+		}
+		for _, e := range tc.expect {
+			if !strings.Contains(err.Error(), e) {
+				t.Errorf("case #%d, error %q does not mention %q", i, err, e)
+			}
+		}
+	}
+}
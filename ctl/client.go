@@ -0,0 +1,115 @@
+package ctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/turekt/wgmon/api"
+	"github.com/turekt/wgmon/history"
+	"github.com/turekt/wgmon/hook"
+	"github.com/turekt/wgmon/wg"
+)
+
+const DefaultSocket = "/run/wgmon.sock"
+
+// Client talks to a running daemon over its control socket or API.
+type Client struct {
+	http  *http.Client
+	base  string
+	token string
+}
+
+// NewClient creates client for addr, which is either a unix socket path,
+// unix:///path/to/socket or http(s):// URL of the API.
+func NewClient(addr, token string) *Client {
+	c := &Client{
+		http:  &http.Client{Timeout: 30 * time.Second},
+		base:  strings.TrimSuffix(addr, "/"),
+		token: token,
+	}
+	if strings.HasPrefix(addr, "http://") || strings.HasPrefix(addr, "https://") {
+		return c
+	}
+
+	path := strings.TrimPrefix(addr, "unix://")
+	c.base = "http://wgmon"
+	c.http.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+	return c
+}
+
+func (c *Client) Devices() ([]wg.DeviceStatus, error) {
+	var devices []wg.DeviceStatus
+	return devices, c.do("GET", "/api/v1/devices", nil, &devices)
+}
+
+// Peers returns peers of device, or all peers if device is empty.
+func (c *Client) Peers(device string) ([]wg.PeerStatus, error) {
+	var peers []wg.PeerStatus
+	return peers, c.do("GET", "/api/v1/peers", url.Values{"device": {device}}, &peers)
+}
+
+// Events returns recent events matching query filters.
+func (c *Client) Events(query url.Values) ([]api.StreamEvent, error) {
+	var events []api.StreamEvent
+	return events, c.do("GET", "/api/v1/events", query, &events)
+}
+
+// Sessions returns recorded sessions matching query filters, newest first.
+func (c *Client) Sessions(query url.Values) ([]history.Session, error) {
+	var sessions []history.Session
+	return sessions, c.do("GET", "/api/v1/sessions", query, &sessions)
+}
+
+// NotifyTest asks daemon to send a test event through all sinks.
+func (c *Client) NotifyTest() ([]hook.SendResult, error) {
+	var results []hook.SendResult
+	return results, c.do("POST", "/api/v1/notify-test", nil, &results)
+}
+
+func (c *Client) do(method, path string, query url.Values, v any) error {
+	u := c.base + path
+	for k, vs := range query {
+		if len(vs) == 1 && vs[0] == "" {
+			delete(query, k)
+		}
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to reach wgmon daemon: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s %s: %s", method, path, apiErr.Error)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
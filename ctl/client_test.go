@@ -0,0 +1,106 @@
+package ctl
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/turekt/wgmon/api"
+	"github.com/turekt/wgmon/hook"
+	"github.com/turekt/wgmon/wg"
+)
+
+type daemonMock struct{}
+
+func (m *daemonMock) Status() ([]wg.DeviceStatus, []wg.PeerStatus, error) {
+	handshake := time.Now().Add(-time.Minute)
+	return []wg.DeviceStatus{{Name: "wg0", PublicKey: "srv=", ListenPort: 51820, Peers: 2, Connected: 1}},
+		[]wg.PeerStatus{
+			{Device: "wg0", PublicKey: "a=", Endpoint: "1.2.3.4:5678", State: "established", Connected: true,
+				LastHandshake: &handshake, ReceiveBytes: 2048, TransmitBytes: 100},
+			{Device: "wg0", PublicKey: "b=", State: "inactive"},
+		}, nil
+}
+
+func (m *daemonMock) NotifyTest() []hook.SendResult {
+	return []hook.SendResult{{Sink: "webhook"}, {Sink: "exec", Error: "exit status 1"}}
+}
+
+func newDaemon(t *testing.T) (*Client, *api.Stream) {
+	socket := filepath.Join(t.TempDir(), "wgmon.sock")
+	stream := api.NewStream(0)
+	server := api.NewServer(&daemonMock{}, "")
+	server.SetStream(stream)
+	server.SetControl(&daemonMock{})
+
+	l, err := api.Listen("unix://" + socket)
+	if err != nil {
+		t.Fatalf("unable to listen on control socket: %v", err)
+	}
+	go http.Serve(l, server)
+	t.Cleanup(func() { l.Close() })
+	return NewClient(socket, ""), stream
+}
+
+func TestClient(t *testing.T) {
+	client, stream := newDaemon(t)
+	stream.Send(hook.NewStateEvent("wg0", "a=", "1.2.3.4:5678", "opened"))
+	stream.Send(hook.NewStateEvent("wg1", "c=", "5.6.7.8:5678", "opened"))
+
+	devices, err := client.Devices()
+	if err != nil {
+		t.Fatalf("devices failed: %v", err)
+	}
+	peers, err := client.Peers("wg0")
+	if err != nil {
+		t.Fatalf("peers failed: %v", err)
+	}
+	var out bytes.Buffer
+	PrintStatus(&out, devices, peers, time.Now())
+	for _, want := range []string{"device: wg0", "peers: 2 (1 connected)", "peer: a=", "state: established (connected)",
+		"latest handshake: 1m0s ago", "transfer: 2.00 KiB received, 100 B sent"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("status output missing %q:\n%s", want, out.String())
+		}
+	}
+
+	events, err := client.Events(url.Values{"device": {"wg1"}})
+	if err != nil {
+		t.Fatalf("events failed: %v", err)
+	}
+	if got, want := len(events), 1; got != want {
+		t.Fatalf("unexpected event count: got %d, want %d", got, want)
+	}
+	if got, want := events[0].Event.ID(), "wg1:c="; got != want {
+		t.Errorf("unexpected event peer: got %s, want %s", got, want)
+	}
+
+	results, err := client.NotifyTest()
+	if err != nil {
+		t.Fatalf("notify test failed: %v", err)
+	}
+	out.Reset()
+	if PrintResults(&out, results) {
+		t.Errorf("expected failed test notification to be reported")
+	}
+	if !strings.Contains(out.String(), "failed: exit status 1") {
+		t.Errorf("results output missing sink error:\n%s", out.String())
+	}
+}
+
+func TestClientUnreachable(t *testing.T) {
+	client := NewClient(filepath.Join(t.TempDir(), "missing.sock"), "")
+	if _, err := client.Devices(); err == nil {
+		t.Errorf("expected error reaching missing socket")
+	}
+	client = NewClient("http://127.0.0.1:1", "")
+	var urlErr *url.Error
+	if _, err := client.Peers(""); !errors.As(err, &urlErr) {
+		t.Errorf("unexpected error type: %v", err)
+	}
+}
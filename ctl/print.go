@@ -0,0 +1,160 @@
+package ctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/turekt/wgmon/api"
+	"github.com/turekt/wgmon/history"
+	"github.com/turekt/wgmon/hook"
+	"github.com/turekt/wgmon/wg"
+)
+
+// PrintJSON writes v as indented JSON.
+func PrintJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// PrintStatus writes devices and their peers in the same layout as wg show,
+// with connection state computed by wgmon.
+func PrintStatus(w io.Writer, devices []wg.DeviceStatus, peers []wg.PeerStatus, now time.Time) {
+	for i, d := range devices {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "device: %s\n", d.Name)
+		fmt.Fprintf(w, "  public key: %s\n", d.PublicKey)
+		fmt.Fprintf(w, "  listening port: %d\n", d.ListenPort)
+		fmt.Fprintf(w, "  peers: %d (%d connected)\n", d.Peers, d.Connected)
+		for _, p := range peers {
+			if p.Device != d.Name {
+				continue
+			}
+			fmt.Fprintf(w, "\npeer: %s\n", p.PublicKey)
+			if p.Name != "" {
+				fmt.Fprintf(w, "  name: %s\n", p.Name)
+			}
+			if p.Owner != "" {
+				fmt.Fprintf(w, "  owner: %s\n", p.Owner)
+			}
+			state := p.State
+			if p.Connected {
+				state += " (connected)"
+			}
+			fmt.Fprintf(w, "  state: %s\n", state)
+			if p.Endpoint != "" {
+				fmt.Fprintf(w, "  endpoint: %s\n", p.Endpoint)
+			}
+			if p.Location != nil {
+				fmt.Fprintf(w, "  location: %s\n", p.Location)
+			}
+			fmt.Fprintf(w, "  allowed ips: %s\n", strings.Join(p.AllowedIPs, ", "))
+			if p.LastHandshake != nil {
+				fmt.Fprintf(w, "  latest handshake: %s\n", ago(*p.LastHandshake, now))
+			}
+			if p.SessionStart != nil {
+				fmt.Fprintf(w, "  session started: %s\n", ago(*p.SessionStart, now))
+			}
+			fmt.Fprintf(w, "  transfer: %s received, %s sent\n", hook.FormatBytes(p.ReceiveBytes), hook.FormatBytes(p.TransmitBytes))
+		}
+	}
+}
+
+// PrintPeers writes peers as a table.
+func PrintPeers(w io.Writer, peers []wg.PeerStatus, now time.Time) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEVICE\tPEER\tENDPOINT\tSTATE\tHANDSHAKE\tRECEIVED\tSENT")
+	for _, p := range peers {
+		handshake := "never"
+		if p.LastHandshake != nil {
+			handshake = ago(*p.LastHandshake, now)
+		}
+		peer := p.PublicKey
+		if p.Name != "" {
+			peer = p.Name
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			p.Device, peer, dash(p.Endpoint), p.State, handshake,
+			hook.FormatBytes(p.ReceiveBytes), hook.FormatBytes(p.TransmitBytes))
+	}
+	tw.Flush()
+}
+
+// PrintEvents writes events as a table, oldest first.
+func PrintEvents(w io.Writer, events []api.StreamEvent) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTIME\tTYPE\tPEER\tENDPOINT\tSTATE")
+	for _, e := range events {
+		peer := "-"
+		if e.Peer != "" {
+			peer = e.Event.Label()
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			e.ID, e.Time.Local().Format(time.DateTime), e.Type, peer, dash(e.Endpoint), dash(e.State))
+	}
+	tw.Flush()
+}
+
+// PrintSessions writes sessions as a table.
+func PrintSessions(w io.Writer, sessions []history.Session, now time.Time) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "START\tEND\tDURATION\tDEVICE\tPEER\tENDPOINTS\tRECEIVED\tSENT")
+	for _, s := range sessions {
+		end, last := "ongoing", now
+		if s.End != nil {
+			end, last = s.End.Local().Format(time.DateTime), *s.End
+		}
+		peer := s.Peer
+		if s.Name != "" {
+			peer = s.Name
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			s.Start.Local().Format(time.DateTime), end, last.Sub(s.Start).Round(time.Second),
+			s.Device, peer, strings.Join(s.Endpoints, ","),
+			hook.FormatBytes(s.ReceiveBytes), hook.FormatBytes(s.TransmitBytes))
+	}
+	tw.Flush()
+}
+
+// PrintResults writes outcome of test notification per sink and reports
+// whether all sinks succeeded.
+func PrintResults(w io.Writer, results []hook.SendResult) bool {
+	if len(results) == 0 {
+		fmt.Fprintln(w, "no sinks configured")
+		return false
+	}
+	ok := true
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SINK\tRESULT")
+	for _, r := range results {
+		result := "ok"
+		if r.Error != "" {
+			result = "failed: " + r.Error
+			ok = false
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", r.Sink, result)
+	}
+	tw.Flush()
+	return ok
+}
+
+func ago(t, now time.Time) string {
+	d := now.Sub(t).Round(time.Second)
+	if d < 0 {
+		d = 0
+	}
+	return d.String() + " ago"
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
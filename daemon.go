@@ -0,0 +1,338 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/turekt/wgmon/anomaly"
+	"github.com/turekt/wgmon/api"
+	"github.com/turekt/wgmon/bandwidth"
+	"github.com/turekt/wgmon/config"
+	"github.com/turekt/wgmon/geoip"
+	"github.com/turekt/wgmon/history"
+	"github.com/turekt/wgmon/hook"
+	"github.com/turekt/wgmon/inventory"
+	"github.com/turekt/wgmon/metrics"
+	"github.com/turekt/wgmon/wg"
+)
+
+// daemon owns tracker and listeners built from configuration and applies
+// configuration reloads to them.
+type daemon struct {
+	cfg     *config.Config
+	tracker *wg.Tracker
+	// sinks built from configuration, replaced on reload
+	sinks []hook.Sink
+	// sinks serving api clients, kept across reloads
+	internal  []hook.Sink
+	history   *history.Store
+	inventory *inventory.Inventory
+	geoip     *geoip.DB
+	anomaly   *anomaly.Detector
+	bandwidth *bandwidth.Monitor
+
+	// done stops background workers tracked by workers
+	done    chan struct{}
+	workers sync.WaitGroup
+}
+
+func newDaemon(cfg *config.Config) (*daemon, error) {
+	sinks, err := cfg.Sinks.NewSinks()
+	if err != nil {
+		return nil, err
+	}
+	tracker, err := wg.NewTracker(cfg.Monitor.NewMonitor(), sinks...)
+	if err != nil {
+		config.CloseSinks(sinks)
+		return nil, fmt.Errorf("failed to initiate tracker: %w", err)
+	}
+	d := &daemon{
+		cfg:       cfg,
+		tracker:   tracker,
+		sinks:     sinks,
+		inventory: cfg.NewInventory(),
+		bandwidth: bandwidth.NewMonitor(cfg.BandwidthLimits()),
+		done:      make(chan struct{}),
+	}
+	d.applyTracker(cfg)
+	tracker.SetStatePath(cfg.State)
+	if len(cfg.GeoIP) > 0 {
+		if d.geoip, err = geoip.Open(cfg.GeoIP...); err != nil {
+			d.stop()
+			return nil, err
+		}
+		tracker.SetLocator(d.geoip)
+	}
+	if cfg.History.Path != "" {
+		d.history, err = history.Open(cfg.History.Path, time.Duration(cfg.History.Retention), cfg.History.Max)
+		if err != nil {
+			d.stop()
+			return nil, err
+		}
+		tracker.SetSessionRecorder(d.history)
+	}
+	d.newAnomalyDetector(cfg)
+	if err := d.listen(); err != nil {
+		d.stop()
+		return nil, err
+	}
+
+	// background workers run for daemon lifetime, start them once nothing
+	// can fail
+	go d.inventory.Watch(inventory.WatchInterval, tracker.SetPeers)
+	if d.history != nil {
+		d.workers.Add(1)
+		go d.refreshHistory()
+	}
+	d.workers.Add(1)
+	go d.watchBandwidth()
+	return d, nil
+}
+
+// newAnomalyDetector enables anomaly alerts using history and geoip
+// databases opened by daemon.
+func (d *daemon) newAnomalyDetector(cfg *config.Config) {
+	var h anomaly.History
+	if d.history != nil {
+		h = d.history
+	}
+	d.anomaly = anomaly.NewDetector(h, cfg.Anomaly.Rules())
+	if d.geoip != nil {
+		d.anomaly.SetLocator(d.geoip)
+	}
+	d.tracker.SetAnomalyDetector(d.anomaly)
+}
+
+// refreshHistory periodically updates transferred bytes of ongoing
+// sessions and prunes old ones.
+func (d *daemon) refreshHistory() {
+	defer d.workers.Done()
+	ticker := time.NewTicker(history.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-d.done:
+			return
+		}
+		_, peers, err := d.tracker.Status()
+		if err != nil {
+			slog.Error("history refresh failed", "error", err)
+			continue
+		}
+		d.history.Refresh(peers, now)
+	}
+}
+
+// watchBandwidth samples peer transfer counters and notifies traffic
+// exceeding bandwidth limits.
+func (d *daemon) watchBandwidth() {
+	defer d.workers.Done()
+	ticker := time.NewTicker(bandwidth.SampleInterval)
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-d.done:
+			return
+		}
+		if !d.bandwidth.Enabled() {
+			continue
+		}
+		_, peers, err := d.tracker.Status()
+		if err != nil {
+			slog.Error("bandwidth snapshot failed", "error", err)
+			continue
+		}
+		for _, e := range d.bandwidth.Update(peers, now) {
+			d.tracker.Notify(e)
+		}
+	}
+}
+
+// applyTracker applies settings changeable without restarting tracker.
+func (d *daemon) applyTracker(cfg *config.Config) {
+	d.tracker.SetFlapDetector(cfg.Flap.NewFlapDetector())
+	d.tracker.SetHealthLimits(time.Duration(cfg.Health.PacketTimeout), cfg.Health.MaxPending)
+	d.tracker.SetAttemptsInterval(time.Duration(cfg.Attempts.Interval))
+
+	d.inventory.Set(cfg.Inventory.WireGuard, cfg.Inventory.File, cfg.Peers)
+	peers, err := d.inventory.Load()
+	if err != nil {
+		slog.Error("inventory load incomplete", "error", err)
+	}
+	d.tracker.SetPeers(peers)
+	// filter selects peers by tags of the inventory
+	d.tracker.SetFilter(cfg.NewFilter())
+}
+
+// listen starts metrics, health, api, grpc and control servers. Listeners
+// are not reconfigured on reload.
+func (d *daemon) listen() error {
+	cfg := d.cfg
+
+	// load certificates before starting any server
+	var tlsConfig *tls.Config
+	if cfg.API.Cert != "" && (cfg.API.Listen != "" || cfg.GRPC != "") {
+		var err error
+		tlsConfig, err = api.NewTLSConfig(cfg.API.Cert, cfg.API.Key, cfg.API.ClientCA)
+		if err != nil {
+			return fmt.Errorf("failed to initiate api tls: %w", err)
+		}
+	}
+
+	// metrics and health endpoints share a listener when addresses match
+	muxes := make(map[string]*http.ServeMux)
+	opsMux := func(addr string) *http.ServeMux {
+		if _, ok := muxes[addr]; !ok {
+			muxes[addr] = http.NewServeMux()
+		}
+		return muxes[addr]
+	}
+	if cfg.Metrics != "" {
+		d.tracker.RegisterMetrics(metrics.Default)
+		opsMux(cfg.Metrics).Handle("/metrics", metrics.Default.Handler())
+	}
+	if cfg.Health.Listen != "" {
+		api.HandleHealth(opsMux(cfg.Health.Listen), d.tracker)
+	}
+	for addr, mux := range muxes {
+		go func() {
+			if err := http.ListenAndServe(addr, mux); err != nil {
+				slog.Error("metrics server failed", "addr", addr, "error", err)
+			}
+		}()
+	}
+
+	var stream *api.Stream
+	if cfg.API.Listen != "" || cfg.GRPC != "" || cfg.Control != "" {
+		stream = api.NewStream(api.StreamDefaultSize)
+		d.internal = append(d.internal, stream)
+		d.tracker.AddSink(stream)
+	}
+	if cfg.API.Listen != "" {
+		server := api.NewServer(d.tracker, cfg.API.Token)
+		server.SetStream(stream)
+		if d.history != nil {
+			server.SetHistory(d.history)
+		}
+		if cfg.API.Dashboard {
+			server.SetDashboard()
+		}
+		go func() {
+			if err := server.ListenAndServe(cfg.API.Listen, tlsConfig); err != nil {
+				slog.Error("api server failed", "error", err)
+			}
+		}()
+	}
+	if cfg.GRPC != "" {
+		server := api.NewGRPCServer(d.tracker, stream, cfg.API.Token, tlsConfig)
+		go func() {
+			if err := server.ListenAndServe(cfg.GRPC); err != nil {
+				slog.Error("grpc server failed", "error", err)
+			}
+		}()
+	}
+	if cfg.Control != "" {
+		server := api.NewServer(d.tracker, "")
+		server.SetStream(stream)
+		server.SetControl(d.tracker)
+		if d.history != nil {
+			server.SetHistory(d.history)
+		}
+		go func() {
+			if err := server.ListenAndServe("unix://"+strings.TrimPrefix(cfg.Control, "unix://"), nil); err != nil {
+				slog.Error("control server failed", "error", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// reload applies changed configuration to running tracker. Sections that
+// fail to apply keep their previous configuration.
+func (d *daemon) reload(cfg *config.Config) {
+	old := d.cfg
+	if !reflect.DeepEqual(old.Monitor, cfg.Monitor) {
+		if err := d.tracker.ReplaceMonitor(cfg.Monitor.NewMonitor()); err != nil {
+			slog.Error("failed to replace monitor, keeping previous", "error", err)
+			cfg.Monitor = old.Monitor
+		}
+	}
+	if !reflect.DeepEqual(old.Sinks, cfg.Sinks) {
+		sinks, err := cfg.Sinks.NewSinks()
+		if err != nil {
+			slog.Error("failed to replace sinks, keeping previous", "error", err)
+			cfg.Sinks = old.Sinks
+		} else {
+			// previous sinks are closed by tracker once drained
+			d.tracker.SetSinks(append(sinks, d.internal...))
+			d.sinks = sinks
+		}
+	}
+	d.applyTracker(cfg)
+	if !slices.Equal(old.GeoIP, cfg.GeoIP) {
+		d.reopenGeoIP(cfg)
+	}
+	d.anomaly.SetRules(cfg.Anomaly.Rules())
+	d.bandwidth.SetLimits(cfg.BandwidthLimits())
+	if d.history != nil {
+		d.history.SetRetention(time.Duration(cfg.History.Retention), cfg.History.Max)
+	}
+
+	listeners := func(c *config.Config) []any {
+		return []any{c.Metrics, c.Health.Listen, c.API, c.GRPC, c.Control, c.History.Path, c.State}
+	}
+	if !reflect.DeepEqual(listeners(old), listeners(cfg)) {
+		slog.Warn("listener, api, history and state path changes require a restart")
+	}
+	d.cfg = cfg
+	slog.Info("configuration reloaded", "sinks", len(d.sinks))
+}
+
+// reopenGeoIP replaces geoip databases, previous ones are kept when new
+// ones fail to open.
+func (d *daemon) reopenGeoIP(cfg *config.Config) {
+	var db *geoip.DB
+	if len(cfg.GeoIP) > 0 {
+		var err error
+		if db, err = geoip.Open(cfg.GeoIP...); err != nil {
+			slog.Error("failed to replace geoip databases, keeping previous", "error", err)
+			cfg.GeoIP = d.cfg.GeoIP
+			return
+		}
+		d.tracker.SetLocator(db)
+		d.anomaly.SetLocator(db)
+	} else {
+		d.tracker.SetLocator(nil)
+		d.anomaly.SetLocator(nil)
+	}
+	if d.geoip != nil {
+		d.geoip.Close()
+	}
+	d.geoip = db
+}
+
+// stop stops background workers and tracker, which also closes its sinks.
+func (d *daemon) stop() {
+	close(d.done)
+	d.workers.Wait()
+	d.tracker.Stop()
+	if d.geoip != nil {
+		d.geoip.Close()
+	}
+	if d.history != nil {
+		if err := d.history.Close(); err != nil {
+			slog.Error("history close error", "error", err)
+		}
+	}
+}
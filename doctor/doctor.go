@@ -0,0 +1,402 @@
+// Package doctor diagnoses why a wgmon setup does not report connections.
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/turekt/wgmon/config"
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const webhookTimeout = 5 * time.Second
+
+type Status int
+
+const (
+	OK Status = iota
+	Warn
+	Fail
+)
+
+func (s Status) String() string {
+	switch s {
+	case OK:
+		return "ok"
+	case Warn:
+		return "warn"
+	default:
+		return "fail"
+	}
+}
+
+// Result is an outcome of a single check with remediation when the check
+// did not pass.
+type Result struct {
+	Name    string `json:"name"`
+	Status  Status `json:"status"`
+	Message string `json:"message"`
+	Fix     string `json:"fix,omitempty"`
+}
+
+// Run checks whether wgmon configured with cfg is able to see wireguard
+// devices, receive packets and deliver notifications.
+func Run(cfg *config.Config) []Result {
+	results := []Result{checkCapabilities(cfg)}
+	devices, r := checkDevices(cfg)
+	results = append(results, r)
+
+	switch cfg.Monitor.Type {
+	case "bpf":
+		results = append(results, checkBPF(cfg, devices)...)
+	case "poll":
+		results = append(results, Result{
+			Name:    "poll",
+			Message: fmt.Sprintf("devices are polled every %s, no packet capture needed", time.Duration(cfg.Monitor.Interval)),
+		})
+	default:
+		results = append(results, checkNFLog(cfg, devices)...)
+	}
+	if cfg.Sinks.Webhook != "" {
+		results = append(results, checkWebhook(cfg.Sinks.Webhook))
+	}
+	return results
+}
+
+// Print writes results in human readable form and reports whether none of
+// the checks failed.
+func Print(w io.Writer, results []Result) bool {
+	ok := true
+	for _, r := range results {
+		fmt.Fprintf(w, "[%-4s] %s: %s\n", strings.ToUpper(r.Status.String()), r.Name, r.Message)
+		if r.Fix != "" {
+			for _, line := range strings.Split(r.Fix, "\n") {
+				fmt.Fprintf(w, "       %s\n", line)
+			}
+		}
+		if r.Status == Fail {
+			ok = false
+		}
+	}
+	return ok
+}
+
+func checkCapabilities(cfg *config.Config) Result {
+	r := Result{Name: "capabilities"}
+	status, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		r.Status = Warn
+		r.Message = "unable to read capabilities: " + err.Error()
+		return r
+	}
+	caps, err := parseCapEff(string(status))
+	if err != nil {
+		r.Status = Warn
+		r.Message = err.Error()
+		return r
+	}
+
+	// wgctrl and nflog need CAP_NET_ADMIN, pcap needs CAP_NET_RAW
+	required := map[string]int{"CAP_NET_ADMIN": unix.CAP_NET_ADMIN}
+	if cfg.Monitor.Type == "bpf" {
+		required["CAP_NET_RAW"] = unix.CAP_NET_RAW
+	}
+	var missing []string
+	for name, c := range required {
+		if caps&(1<<c) == 0 {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		r.Message = "required capabilities are effective"
+		return r
+	}
+	slices.Sort(missing)
+	r.Status = Fail
+	r.Message = "missing " + strings.Join(missing, ", ")
+	r.Fix = "run as root, or grant capabilities:\n" +
+		"  docker: --cap-add NET_ADMIN --cap-add NET_RAW\n" +
+		"  kube: securityContext.capabilities.add [NET_ADMIN, NET_RAW]\n" +
+		"  binary: setcap cap_net_admin,cap_net_raw+ep ./app"
+	return r
+}
+
+// parseCapEff returns effective capability set from /proc/<pid>/status.
+func parseCapEff(status string) (uint64, error) {
+	for _, line := range strings.Split(status, "\n") {
+		if v, ok := strings.CutPrefix(line, "CapEff:"); ok {
+			return strconv.ParseUint(strings.TrimSpace(v), 16, 64)
+		}
+	}
+	return 0, fmt.Errorf("effective capabilities not found")
+}
+
+func checkDevices(cfg *config.Config) ([]*wgtypes.Device, Result) {
+	r := Result{Name: "wireguard devices"}
+	client, err := wgctrl.New()
+	if err != nil {
+		r.Status = Fail
+		r.Message = "wgctrl unavailable: " + err.Error()
+		r.Fix = "load the wireguard kernel module (modprobe wireguard) or run wireguard-go"
+		return nil, r
+	}
+	defer client.Close()
+
+	devices, err := client.Devices()
+	if err != nil {
+		r.Status = Fail
+		r.Message = "unable to list devices: " + err.Error()
+		r.Fix = "grant CAP_NET_ADMIN, see capabilities check"
+		return nil, r
+	}
+	var missing []string
+	for _, pattern := range cfg.Devices {
+		if !slices.ContainsFunc(devices, func(d *wgtypes.Device) bool {
+			ok, _ := path.Match(pattern, d.Name)
+			return ok
+		}) {
+			missing = append(missing, pattern)
+		}
+	}
+	filter := cfg.NewFilter()
+	devices = slices.DeleteFunc(devices, func(d *wgtypes.Device) bool {
+		return !filter.Device(d.Name)
+	})
+	if len(missing) > 0 {
+		r.Status = Fail
+		r.Message = "configured devices not found: " + strings.Join(missing, ", ")
+		r.Fix = "bring the devices up (wg-quick up <device>) or fix devices in configuration"
+		return devices, r
+	}
+	if len(devices) == 0 {
+		r.Status = Fail
+		r.Message = "no wireguard devices visible"
+		r.Fix = "bring a device up (wg-quick up wg0) and run wgmon in the network namespace of the device,\n" +
+			"  e.g. docker network_mode: host or kube hostNetwork: true when wireguard runs on the host"
+		return nil, r
+	}
+
+	var names []string
+	for _, d := range devices {
+		names = append(names, fmt.Sprintf("%s (port %d, %d peers)", d.Name, d.ListenPort, len(d.Peers)))
+	}
+	r.Message = strings.Join(names, ", ")
+	return devices, r
+}
+
+func checkNFLog(cfg *config.Config, devices []*wgtypes.Device) []Result {
+	group := cfg.Monitor.Group
+	rules, nftErr := nftRules()
+	save, iptErr := exec.Command("iptables-save").Output()
+	if nftErr != nil && iptErr != nil {
+		return []Result{{
+			Name:    "nflog rule",
+			Status:  Warn,
+			Message: fmt.Sprintf("unable to read firewall rules: nftables: %v, iptables: %v", nftErr, iptErr),
+			Fix:     fmt.Sprintf("make sure a rule logs wireguard listen port to nflog group %d, see README", group),
+		}}
+	}
+
+	var results []Result
+	for _, d := range devices {
+		r := Result{Name: "nflog rule " + d.Name}
+		port := d.ListenPort
+		switch {
+		case slices.ContainsFunc(rules, func(rule *nftables.Rule) bool { return nftLogsPort(rule, group, port) }):
+			r.Message = fmt.Sprintf("nftables logs udp port %d to group %d", port, group)
+		case iptablesLogsPort(string(save), group, port):
+			r.Message = fmt.Sprintf("iptables logs udp port %d to group %d", port, group)
+		default:
+			r.Status = Fail
+			r.Message = fmt.Sprintf("no rule logs udp port %d to nflog group %d", port, group)
+			r.Fix = "add a logging rule, e.g. in wireguard PostUp:\n" +
+				fmt.Sprintf("  nft add rule inet filter input udp dport %d log group %d\n", port, group) +
+				fmt.Sprintf("  iptables -I INPUT -p udp --dport %d -j NFLOG --nflog-group %d", port, group)
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+func nftRules() ([]*nftables.Rule, error) {
+	c, err := nftables.New()
+	if err != nil {
+		return nil, err
+	}
+	chains, err := c.ListChains()
+	if err != nil {
+		return nil, err
+	}
+	var rules []*nftables.Rule
+	for _, chain := range chains {
+		r, err := c.GetRules(chain.Table, chain)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r...)
+	}
+	return rules, nil
+}
+
+// nftLogsPort reports whether rule logs packets destined to port to nflog
+// group. Rules without port match log every packet.
+func nftLogsPort(rule *nftables.Rule, group uint16, port int) bool {
+	// dport is set while register holds destination port
+	logs, dport, hasPort, matchesPort := false, false, false, false
+	for _, e := range rule.Exprs {
+		switch e := e.(type) {
+		case *expr.Payload:
+			dport = e.Base == expr.PayloadBaseTransportHeader && e.Offset == 2 && e.Len == 2
+		case *expr.Cmp:
+			if dport && e.Op == expr.CmpOpEq {
+				hasPort = true
+				matchesPort = matchesPort || bytes.Equal(e.Data, binaryutil.BigEndian.PutUint16(uint16(port)))
+			}
+			dport = false
+		case *expr.Log:
+			logs = e.Key&(1<<unix.NFTA_LOG_GROUP) != 0 && e.Group == group
+		}
+	}
+	return logs && (matchesPort || !hasPort)
+}
+
+// iptablesLogsPort reports whether iptables-save output contains NFLOG rule
+// for group matching destination port.
+func iptablesLogsPort(save string, group uint16, port int) bool {
+	for _, line := range strings.Split(save, "\n") {
+		fields := strings.Fields(line)
+		if !slices.Contains(fields, "NFLOG") {
+			continue
+		}
+		// NFLOG defaults to group 0
+		ruleGroup, rulePort := "0", ""
+		for i := 0; i < len(fields)-1; i++ {
+			switch fields[i] {
+			case "--nflog-group":
+				ruleGroup = fields[i+1]
+			case "--dport", "--destination-port":
+				rulePort = fields[i+1]
+			}
+		}
+		if ruleGroup == strconv.Itoa(int(group)) && (rulePort == "" || rulePort == strconv.Itoa(port)) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkBPF(cfg *config.Config, devices []*wgtypes.Device) []Result {
+	intf := Result{Name: "interface " + cfg.Monitor.Interface}
+	if _, err := net.InterfaceByName(cfg.Monitor.Interface); err != nil {
+		intf.Status = Fail
+		intf.Message = err.Error()
+		intf.Fix = "set interface to the public facing interface receiving wireguard traffic, see ip link"
+	} else {
+		intf.Message = "present"
+	}
+
+	filter := Result{Name: "bpf filter"}
+	bpf, err := pcap.NewBPF(layers.LinkTypeEthernet, 65535, cfg.Monitor.Filter)
+	if err != nil {
+		filter.Status = Fail
+		filter.Message = fmt.Sprintf("filter %q does not compile: %v", cfg.Monitor.Filter, err)
+		filter.Fix = "use pcap-filter syntax, e.g. udp and dst port 51820"
+		return []Result{intf, filter}
+	}
+	filter.Message = fmt.Sprintf("filter %q compiles", cfg.Monitor.Filter)
+	results := []Result{intf, filter}
+
+	for _, d := range devices {
+		r := Result{Name: "bpf filter " + d.Name}
+		data, err := udpPacket(d.ListenPort)
+		if err != nil {
+			r.Status = Warn
+			r.Message = err.Error()
+		} else if bpf.Matches(gopacket.CaptureInfo{CaptureLength: len(data), Length: len(data)}, data) {
+			r.Message = fmt.Sprintf("matches udp port %d", d.ListenPort)
+		} else {
+			r.Status = Fail
+			r.Message = fmt.Sprintf("filter does not match packets to listen port %d", d.ListenPort)
+			r.Fix = fmt.Sprintf("set filter to \"udp and dst port %d\"", d.ListenPort)
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// udpPacket builds an ethernet frame carrying udp packet to port.
+func udpPacket(port int) ([]byte, error) {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.IPv4(192, 0, 2, 1),
+		DstIP:    net.IPv4(192, 0, 2, 2),
+	}
+	udp := &layers.UDP{SrcPort: 51000, DstPort: layers.UDPPort(port)}
+	udp.SetNetworkLayerForChecksum(ip)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload(make([]byte, 148))); err != nil {
+		return nil, fmt.Errorf("unable to build test packet: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// checkWebhook verifies webhook host is reachable without posting an event,
+// notify-test delivers a real one.
+func checkWebhook(webhook string) Result {
+	r := Result{Name: "webhook"}
+	u, err := url.Parse(webhook)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		r.Status = Fail
+		r.Message = fmt.Sprintf("invalid webhook url %q", webhook)
+		r.Fix = "use an http:// or https:// url"
+		return r
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, webhook, nil)
+	if err != nil {
+		r.Status = Fail
+		r.Message = err.Error()
+		return r
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		r.Status = Fail
+		r.Message = fmt.Sprintf("%s unreachable: %v", u.Host, err)
+		r.Fix = "check DNS, firewall and proxy settings of the wgmon host"
+		return r
+	}
+	resp.Body.Close()
+	r.Message = fmt.Sprintf("%s reachable (HEAD returned %s)", u.Host, resp.Status)
+	return r
+}
@@ -0,0 +1,96 @@
+package doctor
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseCapEff(t *testing.T) {
+	status := "Name:\twgmon\nCapInh:\t0000000000000000\nCapEff:\t0000000000003000\n"
+	caps, err := parseCapEff(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := caps&(1<<unix.CAP_NET_ADMIN) != 0, true; got != want {
+		t.Errorf("unexpected CAP_NET_ADMIN: got %v, want %v", got, want)
+	}
+	if got, want := caps&(1<<unix.CAP_SYS_ADMIN) != 0, false; got != want {
+		t.Errorf("unexpected CAP_SYS_ADMIN: got %v, want %v", got, want)
+	}
+	if _, err := parseCapEff("Name:\twgmon\n"); err == nil {
+		t.Error("expected error for missing CapEff")
+	}
+}
+
+func TestNFTLogsPort(t *testing.T) {
+	dport := func(port uint16) []expr.Any {
+		return []expr.Any{
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.BigEndian.PutUint16(port)},
+		}
+	}
+	log := func(group uint16) expr.Any {
+		return &expr.Log{Key: 1 << unix.NFTA_LOG_GROUP, Group: group}
+	}
+	testCases := []struct {
+		exprs  []expr.Any
+		expect bool
+	}{
+		{append(dport(3000), log(1)), true},
+		{append(dport(3000), log(2)), false},
+		{append(dport(51820), log(1)), false},
+		// logs every packet
+		{[]expr.Any{log(1)}, true},
+		// logs to syslog
+		{append(dport(3000), &expr.Log{}), false},
+		{dport(3000), false},
+	}
+	for i, tc := range testCases {
+		rule := &nftables.Rule{Exprs: tc.exprs}
+		if got, want := nftLogsPort(rule, 1, 3000), tc.expect; got != want {
+			t.Errorf("case #%d, unexpected result: got %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestIptablesLogsPort(t *testing.T) {
+	testCases := []struct {
+		save   string
+		expect bool
+	}{
+		{"-A INPUT -p udp -m udp --dport 3000 -j NFLOG --nflog-group 1", true},
+		{"-A INPUT -p udp -m udp --dport 3000 -j NFLOG --nflog-group 2", false},
+		{"-A INPUT -p udp -m udp --dport 51820 -j NFLOG --nflog-group 1", false},
+		{"-A INPUT -p udp -j NFLOG --nflog-group 1", true},
+		{"-A INPUT -p udp -m udp --dport 3000 -j ACCEPT", false},
+		{"*filter\n:INPUT ACCEPT [0:0]\n-A INPUT -p udp --dport 3000 -j NFLOG --nflog-group 1\nCOMMIT", true},
+	}
+	for i, tc := range testCases {
+		if got, want := iptablesLogsPort(tc.save, 1, 3000), tc.expect; got != want {
+			t.Errorf("case #%d, unexpected result: got %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+	var buf bytes.Buffer
+	ok := Print(&buf, []Result{
+		{Name: "capabilities", Message: "required capabilities are effective"},
+		{Name: "nflog rule wg0", Status: Fail, Message: "no rule", Fix: "add a rule:\n  nft add rule"},
+	})
+	if ok {
+		t.Error("expected failure")
+	}
+	want := "[OK  ] capabilities: required capabilities are effective\n" +
+		"[FAIL] nflog rule wg0: no rule\n" +
+		"       add a rule:\n" +
+		"         nft add rule\n"
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,136 @@
+// Package geoip looks up location and network owner of endpoints in local
+// MaxMind GeoLite2 or GeoIP2 databases.
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Location of an endpoint, fields missing in the database are empty.
+type Location struct {
+	Country     string `json:"country,omitempty"`
+	CountryName string `json:"country_name,omitempty"`
+	City        string `json:"city,omitempty"`
+	ASN         uint   `json:"asn,omitempty"`
+	Org         string `json:"org,omitempty"`
+}
+
+// String formats location as "Berlin, DE, AS3320 Deutsche Telekom AG".
+func (l *Location) String() string {
+	var parts []string
+	for _, p := range []string{l.City, l.Country} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if l.ASN != 0 {
+		parts = append(parts, strings.TrimSpace(fmt.Sprintf("AS%d %s", l.ASN, l.Org)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// cityRecord is the subset of City and Country databases used by wgmon.
+type cityRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+}
+
+type asnRecord struct {
+	ASN uint   `maxminddb:"autonomous_system_number"`
+	Org string `maxminddb:"autonomous_system_organization"`
+}
+
+// DB combines a City or Country database with an ASN database, either may
+// be missing.
+type DB struct {
+	mu   sync.RWMutex
+	city *maxminddb.Reader
+	asn  *maxminddb.Reader
+}
+
+// Open opens databases at paths, their kind is recognized from metadata.
+func Open(paths ...string) (*DB, error) {
+	db := &DB{}
+	for _, path := range paths {
+		r, err := maxminddb.Open(path)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to open geoip database %s: %w", path, err)
+		}
+		kind := r.Metadata.DatabaseType
+		switch {
+		case strings.Contains(kind, "ASN") && db.asn == nil:
+			db.asn = r
+		case (strings.Contains(kind, "City") || strings.Contains(kind, "Country")) && db.city == nil:
+			db.city = r
+		default:
+			r.Close()
+			db.Close()
+			return nil, fmt.Errorf("unsupported or duplicate geoip database %s of type %q", path, kind)
+		}
+	}
+	if db.city == nil && db.asn == nil {
+		return nil, errors.New("no geoip database")
+	}
+	return db, nil
+}
+
+// Lookup returns location of endpoint given as address or address:port,
+// nil when it is unknown.
+func (db *DB) Lookup(endpoint string) *Location {
+	host := endpoint
+	if h, _, err := net.SplitHostPort(endpoint); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return nil
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var loc Location
+	if db.city != nil {
+		var r cityRecord
+		if err := db.city.Lookup(ip, &r); err == nil {
+			loc.Country = r.Country.ISOCode
+			loc.CountryName = r.Country.Names["en"]
+			loc.City = r.City.Names["en"]
+		}
+	}
+	if db.asn != nil {
+		var r asnRecord
+		if err := db.asn.Lookup(ip, &r); err == nil {
+			loc.ASN, loc.Org = r.ASN, r.Org
+		}
+	}
+	if loc == (Location{}) {
+		return nil
+	}
+	return &loc
+}
+
+// Close releases databases, following lookups find nothing.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var errs []error
+	for _, r := range []*maxminddb.Reader{db.city, db.asn} {
+		if r != nil {
+			errs = append(errs, r.Close())
+		}
+	}
+	db.city, db.asn = nil, nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,148 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// encode writes value in MaxMind DB data section format, supporting the
+// types used by test databases.
+func encode(b *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case string:
+		if len(v) < 29 {
+			b.WriteByte(2<<5 | byte(len(v)))
+		} else {
+			b.Write([]byte{2<<5 | 29, byte(len(v) - 29)})
+		}
+		b.WriteString(v)
+	case uint32:
+		b.WriteByte(6<<5 | 4)
+		b.Write(binary.BigEndian.AppendUint32(nil, v))
+	case uint16:
+		b.WriteByte(5<<5 | 2)
+		b.Write(binary.BigEndian.AppendUint16(nil, v))
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		b.WriteByte(7<<5 | byte(len(v)))
+		for _, k := range keys {
+			encode(b, k)
+			encode(b, v[k])
+		}
+	}
+}
+
+// writeDB creates IPv4 database of kind holding record for a single network.
+func writeDB(t *testing.T, kind, cidr string, record map[string]any) string {
+	t.Helper()
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := network.IP.To4()
+	bits, _ := network.Mask.Size()
+	nodes := uint32(bits)
+
+	var buf bytes.Buffer
+	record24 := func(v uint32) {
+		buf.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+	}
+	for i := uint32(0); i < nodes; i++ {
+		next := i + 1
+		if next == nodes {
+			// pointer to the first record of data section
+			next = nodes + 16
+		}
+		if ip[i/8]>>(7-i%8)&1 == 0 {
+			record24(next)
+			record24(nodes)
+		} else {
+			record24(nodes)
+			record24(next)
+		}
+	}
+	buf.Write(make([]byte, 16))
+	encode(&buf, record)
+	buf.WriteString("\xab\xcd\xefMaxMind.com")
+	encode(&buf, map[string]any{
+		"binary_format_major_version": uint16(2),
+		"database_type":               kind,
+		"ip_version":                  uint16(4),
+		"node_count":                  nodes,
+		"record_size":                 uint16(24),
+	})
+
+	path := filepath.Join(t.TempDir(), kind+".mmdb")
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLookup(t *testing.T) {
+	city := writeDB(t, "GeoLite2-City", "81.2.69.0/24", map[string]any{
+		"city":    map[string]any{"names": map[string]any{"en": "London"}},
+		"country": map[string]any{"iso_code": "GB", "names": map[string]any{"en": "United Kingdom"}},
+	})
+	asn := writeDB(t, "GeoLite2-ASN", "81.2.0.0/16", map[string]any{
+		"autonomous_system_number":       uint32(20712),
+		"autonomous_system_organization": "Andrews & Arnold Ltd",
+	})
+	db, err := Open(city, asn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	testCases := []struct {
+		endpoint string
+		expect   string
+	}{
+		{"81.2.69.160:51820", "London, GB, AS20712 Andrews & Arnold Ltd"},
+		{"81.2.1.1", "AS20712 Andrews & Arnold Ltd"},
+		{"8.8.8.8:53", ""},
+		{"10.0.0.1:51820", ""},
+		{"[2001:db8::1]:51820", ""},
+		{"invalid", ""},
+	}
+	for i, tc := range testCases {
+		got := ""
+		if l := db.Lookup(tc.endpoint); l != nil {
+			got = l.String()
+		}
+		if got != tc.expect {
+			t.Errorf("case #%d %s, unexpected location: got %q, want %q", i, tc.endpoint, got, tc.expect)
+		}
+	}
+	if got, want := db.Lookup("81.2.69.160").CountryName, "United Kingdom"; got != want {
+		t.Errorf("unexpected country name: got %v, want %v", got, want)
+	}
+
+	db.Close()
+	if l := db.Lookup("81.2.69.160"); l != nil {
+		t.Errorf("unexpected location after close: %v", l)
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	asn := writeDB(t, "GeoLite2-ASN", "81.2.0.0/16", map[string]any{})
+	other := writeDB(t, "GeoIP2-Anonymous-IP", "81.2.0.0/16", map[string]any{})
+	garbage := filepath.Join(t.TempDir(), "garbage.mmdb")
+	os.WriteFile(garbage, []byte("garbage"), 0600)
+
+	for i, paths := range [][]string{{}, {other}, {asn, asn}, {garbage}, {"missing.mmdb"}} {
+		if db, err := Open(paths...); err == nil {
+			db.Close()
+			t.Errorf("case #%d %v, expected error", i, paths)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+// Package history keeps completed and ongoing peer sessions in a local
+// bbolt database.
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/turekt/wgmon/hook"
+	"github.com/turekt/wgmon/wg"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	DefaultRetention = 30 * 24 * time.Hour
+	// RefreshInterval is how often byte counters of ongoing sessions are
+	// updated and old sessions pruned.
+	RefreshInterval = time.Minute
+	DefaultLimit    = 100
+
+	// staleTimeout closes ongoing sessions of peers no longer connected
+	// when their close was never recorded
+	staleTimeout = 10 * time.Minute
+)
+
+var sessionsBucket = []byte("sessions")
+
+// Session is a single connection of a peer, End is nil while the session
+// is ongoing.
+type Session struct {
+	ID            uint64     `json:"id"`
+	Device        string     `json:"device"`
+	Peer          string     `json:"peer"`
+	Name          string     `json:"name,omitempty"`
+	Owner         string     `json:"owner,omitempty"`
+	Endpoints     []string   `json:"endpoints"`
+	Start         time.Time  `json:"start"`
+	End           *time.Time `json:"end,omitempty"`
+	ReceiveBytes  int64      `json:"receive_bytes"`
+	TransmitBytes int64      `json:"transmit_bytes"`
+}
+
+// record is a stored session together with peer counters at session
+// start, which transferred bytes are computed from.
+type record struct {
+	Session
+	Updated         time.Time `json:"updated"`
+	ReceiveCounter  int64     `json:"receive_counter"`
+	TransmitCounter int64     `json:"transmit_counter"`
+}
+
+// update sets transferred bytes from current peer counters. Counters are
+// reset when device is recreated, bytes then only grow from the new base.
+func (r *record) update(receive, transmit int64, now time.Time) {
+	if receive < r.ReceiveCounter || transmit < r.TransmitCounter {
+		r.ReceiveCounter = receive - r.ReceiveBytes
+		r.TransmitCounter = transmit - r.TransmitBytes
+	}
+	r.ReceiveBytes = receive - r.ReceiveCounter
+	r.TransmitBytes = transmit - r.TransmitCounter
+	r.Updated = now
+}
+
+// Query selects sessions, zero values match everything. Sessions match the
+// time range when they overlap it.
+type Query struct {
+	Device   string
+	Peer     string
+	Endpoint string
+	From     time.Time
+	To       time.Time
+	Limit    int
+}
+
+func (q *Query) match(s *Session) bool {
+	if q.Device != "" && s.Device != q.Device {
+		return false
+	}
+	if q.Peer != "" && s.Peer != q.Peer && s.Name != q.Peer {
+		return false
+	}
+	if q.Endpoint != "" && !slices.ContainsFunc(s.Endpoints, func(e string) bool {
+		host, _, err := net.SplitHostPort(e)
+		return e == q.Endpoint || (err == nil && host == q.Endpoint)
+	}) {
+		return false
+	}
+	if !q.To.IsZero() && s.Start.After(q.To) {
+		return false
+	}
+	if !q.From.IsZero() && s.End != nil && s.End.Before(q.From) {
+		return false
+	}
+	return true
+}
+
+// Store records sessions from tracker state changes.
+type Store struct {
+	db *bolt.DB
+
+	mu        sync.Mutex
+	open      map[string]uint64 // device:peer to id of ongoing session
+	retention time.Duration
+	max       int
+	lastPrune time.Time
+}
+
+// Open opens or creates database at path. Sessions left open by a previous
+// run continue, they are closed by tracker reconciling its saved state or
+// once they become stale.
+func Open(path string, retention time.Duration, max int) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history %s: %w", path, err)
+	}
+	s := &Store{db: db, open: make(map[string]uint64)}
+	s.SetRetention(retention, max)
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(sessionsBucket)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			r, err := decode(v)
+			if err == nil && r.End == nil {
+				s.open[r.Device+":"+r.Peer] = r.ID
+			}
+			return err
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize history %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// SetRetention configures how long closed sessions are kept and how many
+// at most, zero max keeps any number.
+func (s *Store) SetRetention(retention time.Duration, max int) {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retention, s.max = retention, max
+	s.lastPrune = time.Time{}
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Record implements wg.SessionRecorder, opening sessions on opened state,
+// closing them on closed state and appending endpoints on roaming. The
+// latest endpoint of a session is always the last one.
+func (s *Store) Record(e *hook.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := e.Device + ":" + e.Peer
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sessionsBucket)
+		r, err := s.ongoing(b, id)
+		if err != nil {
+			return err
+		}
+
+		opened := e.Type == hook.EventState && e.State == wg.ConnectionOpened.String()
+		if opened && r != nil && e.Time.Sub(r.Updated) > staleTimeout {
+			if err := s.closeStale(b, id, r); err != nil {
+				return err
+			}
+			r = nil
+		}
+
+		switch {
+		case opened && r == nil:
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			r = &record{
+				Session: Session{
+					ID:        seq,
+					Device:    e.Device,
+					Peer:      e.Peer,
+					Name:      e.Name,
+					Owner:     e.Owner,
+					Endpoints: []string{e.Endpoint},
+					Start:     e.Time,
+				},
+				Updated:         e.Time,
+				ReceiveCounter:  e.ReceiveBytes,
+				TransmitCounter: e.TransmitBytes,
+			}
+			s.open[id] = seq
+		case r == nil:
+			return nil
+		case e.Type == hook.EventState && e.State == wg.ConnectionClosed.String():
+			// connections are tracked per endpoint, closing of an endpoint
+			// the peer roamed away from does not end the session
+			if r.Endpoints[len(r.Endpoints)-1] != e.Endpoint {
+				return nil
+			}
+			r.update(e.ReceiveBytes, e.TransmitBytes, e.Time)
+			end := e.Time
+			r.End = &end
+			delete(s.open, id)
+		case e.Type == hook.EventRoam, opened:
+			if i := slices.Index(r.Endpoints, e.Endpoint); i >= 0 {
+				r.Endpoints = slices.Delete(r.Endpoints, i, i+1)
+			}
+			r.Endpoints = append(r.Endpoints, e.Endpoint)
+			r.Updated = e.Time
+		default:
+			return nil
+		}
+		return put(b, r)
+	})
+	if err != nil {
+		slog.Error("history record failed", "device", e.Device, "peer", e.Peer, "error", err)
+	}
+}
+
+// ongoing returns open session of peer id, nil when there is none.
+func (s *Store) ongoing(b *bolt.Bucket, id string) (*record, error) {
+	seq, ok := s.open[id]
+	if !ok {
+		return nil, nil
+	}
+	v := b.Get(key(seq))
+	if v == nil {
+		delete(s.open, id)
+		return nil, nil
+	}
+	return decode(v)
+}
+
+// Refresh updates transferred bytes of ongoing sessions from current peer
+// status, closes stale sessions and prunes sessions past retention.
+func (s *Store) Refresh(peers []wg.PeerStatus, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sessionsBucket)
+		for _, p := range peers {
+			if !p.Connected {
+				continue
+			}
+			r, err := s.ongoing(b, p.ID())
+			if err != nil {
+				return err
+			}
+			if r == nil {
+				continue
+			}
+			r.update(p.ReceiveBytes, p.TransmitBytes, now)
+			if err := put(b, r); err != nil {
+				return err
+			}
+		}
+		for id := range s.open {
+			r, err := s.ongoing(b, id)
+			if err != nil {
+				return err
+			}
+			if r != nil && now.Sub(r.Updated) > staleTimeout {
+				if err := s.closeStale(b, id, r); err != nil {
+					return err
+				}
+			}
+		}
+
+		if now.Sub(s.lastPrune) < time.Hour {
+			return nil
+		}
+		s.lastPrune = now
+		return s.prune(b, now)
+	})
+	if err != nil {
+		slog.Error("history refresh failed", "error", err)
+	}
+}
+
+// closeStale ends session at its last update.
+func (s *Store) closeStale(b *bolt.Bucket, id string, r *record) error {
+	end := r.Updated
+	r.End = &end
+	delete(s.open, id)
+	return put(b, r)
+}
+
+// prune deletes closed sessions ended before retention and oldest closed
+// sessions above max.
+func (s *Store) prune(b *bolt.Bucket, now time.Time) error {
+	excess := b.Stats().KeyN - s.max
+	var expired [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		r, err := decode(v)
+		if err != nil {
+			return err
+		}
+		if r.End == nil {
+			return nil
+		}
+		if now.Sub(*r.End) > s.retention || (s.max > 0 && excess > 0) {
+			expired = append(expired, slices.Clone(k))
+			excess--
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range expired {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	if len(expired) > 0 {
+		slog.Info("pruned history", "sessions", len(expired))
+	}
+	return nil
+}
+
+// Sessions returns sessions matching q, newest first.
+func (s *Store) Sessions(q Query) ([]Session, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	sessions := []Session{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(sessionsBucket).Cursor()
+		for k, v := c.Last(); k != nil && len(sessions) < q.Limit; k, v = c.Prev() {
+			r, err := decode(v)
+			if err != nil {
+				return err
+			}
+			if q.match(&r.Session) {
+				sessions = append(sessions, r.Session)
+			}
+		}
+		return nil
+	})
+	return sessions, err
+}
+
+func key(id uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, id)
+}
+
+func put(b *bolt.Bucket, r *record) error {
+	v, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return b.Put(key(r.ID), v)
+}
+
+func decode(v []byte) (*record, error) {
+	r := &record{}
+	if err := json.Unmarshal(v, r); err != nil {
+		return nil, fmt.Errorf("corrupted session: %w", err)
+	}
+	return r, nil
+}
@@ -0,0 +1,172 @@
+package history
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/turekt/wgmon/hook"
+	"github.com/turekt/wgmon/wg"
+)
+
+func stateEvent(peer, endpoint, state string, at time.Time, bytes int64) *hook.Event {
+	e := hook.NewStateEvent("wg0", peer, endpoint, state)
+	e.Time = at
+	e.ReceiveBytes, e.TransmitBytes = bytes, bytes
+	return e
+}
+
+func roamEvent(peer, previous, endpoint string, at time.Time) *hook.Event {
+	e := hook.NewRoamEvent("wg0", peer, previous, endpoint)
+	e.Time = at
+	return e
+}
+
+func openStore(t *testing.T, path string) *Store {
+	t.Helper()
+	s, err := Open(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStoreRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	s := openStore(t, path)
+	start := time.Date(2024, 5, 14, 13, 0, 0, 0, time.UTC)
+
+	s.Record(stateEvent("A", "1.1.1.1:1000", "opened", start, 100))
+	s.Record(roamEvent("A", "1.1.1.1:1000", "2.2.2.2:2000", start.Add(10*time.Minute)))
+	s.Record(stateEvent("A", "2.2.2.2:2000", "opened", start.Add(12*time.Minute), 500))
+	// endpoint left behind by roaming closes without ending the session
+	s.Record(stateEvent("A", "1.1.1.1:1000", "closed", start.Add(15*time.Minute), 600))
+	s.Record(stateEvent("B", "3.3.3.3:3000", "opened", start.Add(30*time.Minute), 0))
+	s.Refresh([]wg.PeerStatus{
+		{Device: "wg0", PublicKey: "A", Connected: true, ReceiveBytes: 900, TransmitBytes: 900},
+		{Device: "wg0", PublicKey: "B", Connected: true},
+	}, start.Add(time.Hour))
+	s.Record(stateEvent("A", "2.2.2.2:2000", "closed", start.Add(2*time.Hour), 1100))
+
+	sessions, err := s.Sessions(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(sessions), 2; got != want {
+		t.Fatalf("unexpected sessions: got %d, want %d", got, want)
+	}
+	a := sessions[1]
+	if got, want := a.Endpoints, []string{"1.1.1.1:1000", "2.2.2.2:2000"}; !slices.Equal(got, want) {
+		t.Errorf("unexpected endpoints: got %v, want %v", got, want)
+	}
+	if a.End == nil || !a.End.Equal(start.Add(2*time.Hour)) {
+		t.Errorf("unexpected end: %v", a.End)
+	}
+	if got, want := a.ReceiveBytes, int64(1000); got != want {
+		t.Errorf("unexpected received bytes: got %d, want %d", got, want)
+	}
+	if sessions[0].End != nil {
+		t.Errorf("session of B should be ongoing, ended %v", sessions[0].End)
+	}
+
+	// ongoing sessions continue after restart and are closed at their last
+	// update once stale
+	s.Refresh([]wg.PeerStatus{{Device: "wg0", PublicKey: "B", Connected: true}}, start.Add(2*time.Hour))
+	s.Refresh([]wg.PeerStatus{{Device: "wg0", PublicKey: "B", Connected: true}}, start.Add(3*time.Hour))
+	s.Close()
+	s = openStore(t, path)
+	defer s.Close()
+	s.Record(roamEvent("B", "3.3.3.3:3000", "4.4.4.4:4000", start.Add(3*time.Hour+time.Minute)))
+	s.Refresh([]wg.PeerStatus{{Device: "wg0", PublicKey: "B"}}, start.Add(4*time.Hour))
+	sessions, err = s.Sessions(Query{Peer: "B"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].End == nil || !sessions[0].End.Equal(start.Add(3*time.Hour+time.Minute)) {
+		t.Errorf("unexpected sessions after reopen: %+v", sessions)
+	}
+
+	// session not updated for a long time is not continued
+	s.Record(stateEvent("C", "5.5.5.5:5000", "opened", start, 0))
+	s.Record(stateEvent("C", "5.5.5.5:5000", "opened", start.Add(time.Hour), 0))
+	sessions, err = s.Sessions(Query{Peer: "C"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[1].End == nil || !sessions[1].End.Equal(start) {
+		t.Errorf("unexpected stale sessions: %+v", sessions)
+	}
+}
+
+func TestStoreQuery(t *testing.T) {
+	s := openStore(t, filepath.Join(t.TempDir(), "history.db"))
+	defer s.Close()
+	day := time.Date(2024, 5, 14, 0, 0, 0, 0, time.UTC)
+
+	alice := stateEvent("A", "1.1.1.1:1000", "opened", day.Add(9*time.Hour), 0)
+	alice.Name = "alice"
+	s.Record(alice)
+	s.Record(stateEvent("A", "1.1.1.1:1000", "closed", day.Add(17*time.Hour), 0))
+	s.Record(stateEvent("B", "2.2.2.2:2000", "opened", day.Add(13*time.Hour), 0))
+	s.Record(stateEvent("B", "2.2.2.2:2000", "closed", day.Add(15*time.Hour), 0))
+	s.Record(stateEvent("C", "3.3.3.3:3000", "opened", day.Add(20*time.Hour), 0))
+
+	testCases := []struct {
+		query  Query
+		expect []string
+	}{
+		{Query{}, []string{"C", "B", "A"}},
+		{Query{Peer: "alice"}, []string{"A"}},
+		{Query{Endpoint: "2.2.2.2"}, []string{"B"}},
+		{Query{Endpoint: "2.2.2.2:2001"}, nil},
+		{Query{From: day.Add(14 * time.Hour), To: day.Add(14 * time.Hour)}, []string{"B", "A"}},
+		{Query{From: day.Add(16 * time.Hour)}, []string{"C", "A"}},
+		{Query{To: day.Add(10 * time.Hour)}, []string{"A"}},
+		// ongoing session matches any later time
+		{Query{From: day.Add(48 * time.Hour)}, []string{"C"}},
+		{Query{Limit: 1}, []string{"C"}},
+		{Query{Device: "wg1"}, nil},
+	}
+	for i, tc := range testCases {
+		sessions, err := s.Sessions(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, s := range sessions {
+			got = append(got, s.Peer)
+		}
+		if !slices.Equal(got, tc.expect) {
+			t.Errorf("case #%d, unexpected sessions: got %v, want %v", i, got, tc.expect)
+		}
+	}
+}
+
+func TestStorePrune(t *testing.T) {
+	s := openStore(t, filepath.Join(t.TempDir(), "history.db"))
+	defer s.Close()
+	s.SetRetention(24*time.Hour, 3)
+	now := time.Date(2024, 5, 14, 0, 0, 0, 0, time.UTC)
+
+	for i, peer := range []string{"A", "B", "C", "D"} {
+		at := now.Add(time.Duration(i-3) * 12 * time.Hour)
+		s.Record(stateEvent(peer, "1.1.1.1:1000", "opened", at.Add(-time.Hour), 0))
+		s.Record(stateEvent(peer, "1.1.1.1:1000", "closed", at, 0))
+	}
+	s.Record(stateEvent("E", "1.1.1.1:1000", "opened", now, 0))
+	s.Refresh(nil, now)
+
+	sessions, err := s.Sessions(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range sessions {
+		got = append(got, s.Peer)
+	}
+	// A is past retention, B is oldest closed session above max
+	if want := []string{"E", "D", "C"}; !slices.Equal(got, want) {
+		t.Errorf("unexpected sessions: got %v, want %v", got, want)
+	}
+}
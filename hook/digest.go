@@ -0,0 +1,110 @@
+package hook
+
+import (
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+const DigestDefaultMax = 50
+
+// DigestSink buffers events for a window and forwards them to the wrapped
+// sink as a single digest event. Buffer is flushed once the window
+// started by the first buffered event elapses or when max events are
+// collected. Events whose type or state is listed as priority are
+// forwarded immediately, as are test events.
+type DigestSink struct {
+	sink     Sink
+	window   time.Duration
+	max      int
+	priority []string
+
+	mu     sync.Mutex
+	events []*Event
+	timer  *time.Timer
+}
+
+func NewDigestSink(sink Sink, window time.Duration, max int, priority []string) *DigestSink {
+	if max <= 0 {
+		max = DigestDefaultMax
+	}
+	return &DigestSink{
+		sink:     sink,
+		window:   window,
+		max:      max,
+		priority: priority,
+	}
+}
+
+func (d *DigestSink) Name() string {
+	return d.sink.Name()
+}
+
+func (d *DigestSink) Send(e *Event) error {
+	if d.isPriority(e) {
+		return d.sink.Send(e)
+	}
+
+	d.mu.Lock()
+	d.events = append(d.events, e)
+	if len(d.events) < d.max {
+		if d.timer == nil {
+			d.timer = time.AfterFunc(d.window, func() {
+				if err := d.Flush(); err != nil {
+					slog.Error("digest flush error", "sink", d.Name(), "error", err)
+				}
+			})
+		}
+		d.mu.Unlock()
+		return nil
+	}
+	batch := d.take()
+	d.mu.Unlock()
+	return d.deliver(batch)
+}
+
+// Flush immediately forwards buffered events.
+func (d *DigestSink) Flush() error {
+	d.mu.Lock()
+	batch := d.take()
+	d.mu.Unlock()
+	return d.deliver(batch)
+}
+
+func (d *DigestSink) Close() error {
+	err := d.Flush()
+	if c, ok := d.sink.(io.Closer); ok {
+		if cerr := c.Close(); cerr != nil {
+			return cerr
+		}
+	}
+	return err
+}
+
+// take must be called with mu held.
+func (d *DigestSink) take() []*Event {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	batch := d.events
+	d.events = nil
+	return batch
+}
+
+func (d *DigestSink) deliver(batch []*Event) error {
+	switch len(batch) {
+	case 0:
+		return nil
+	case 1:
+		return d.sink.Send(batch[0])
+	}
+	return d.sink.Send(NewDigestEvent(batch))
+}
+
+func (d *DigestSink) isPriority(e *Event) bool {
+	return e.Type == EventTest || slices.Contains(d.priority, e.Type.String()) ||
+		(e.State != "" && slices.Contains(d.priority, e.State))
+}
@@ -0,0 +1,83 @@
+package hook
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordSink struct {
+	mu     sync.Mutex
+	events []*Event
+}
+
+func (r *recordSink) Name() string {
+	return "record"
+}
+
+func (r *recordSink) Send(e *Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+func (r *recordSink) Events() []*Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Event(nil), r.events...)
+}
+
+func TestDigestSink(t *testing.T) {
+	rec := &recordSink{}
+	d := NewDigestSink(rec, 100*time.Millisecond, 3, []string{"closed"})
+
+	d.Send(NewStateEvent("wg0", "a", "", "opened"))
+	d.Send(NewStateEvent("wg0", "b", "", "opened"))
+	if got, want := len(rec.Events()), 0; got != want {
+		t.Fatalf("unexpected events before window: got %d, want %d", got, want)
+	}
+
+	// priority event bypasses buffer
+	d.Send(NewStateEvent("wg0", "c", "", "closed"))
+	if got, want := len(rec.Events()), 1; got != want {
+		t.Fatalf("unexpected events after priority: got %d, want %d", got, want)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	events := rec.Events()
+	if got, want := len(events), 2; got != want {
+		t.Fatalf("unexpected events after window: got %d, want %d", got, want)
+	}
+	digest := events[1]
+	if got, want := digest.Type, EventDigest; got != want {
+		t.Fatalf("unexpected event type: got %v, want %v", got, want)
+	}
+	if got, want := digest.Counts()["opened"], 2; got != want {
+		t.Errorf("unexpected opened count: got %d, want %d", got, want)
+	}
+	if msg := digest.Message(); !strings.Contains(msg, "Digest of 2 events") || !strings.Contains(msg, "wg0:b") {
+		t.Errorf("unexpected digest message: %q", msg)
+	}
+
+	// reaching the cap flushes without waiting for window
+	for _, peer := range []string{"d", "e", "f"} {
+		d.Send(NewStateEvent("wg0", peer, "", "opened"))
+	}
+	events = rec.Events()
+	if got, want := len(events), 3; got != want {
+		t.Fatalf("unexpected events after cap: got %d, want %d", got, want)
+	}
+	if got, want := len(events[2].Events), 3; got != want {
+		t.Errorf("unexpected digest size: got %d, want %d", got, want)
+	}
+
+	// single buffered event is forwarded as is
+	d.Send(NewStateEvent("wg0", "g", "", "opened"))
+	d.Close()
+	events = rec.Events()
+	if got, want := events[len(events)-1].Type, EventState; got != want {
+		t.Errorf("unexpected single event type: got %v, want %v", got, want)
+	}
+}
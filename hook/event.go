@@ -0,0 +1,448 @@
+package hook
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/turekt/wgmon/geoip"
+	"github.com/turekt/wgmon/network"
+)
+
+type EventType int
+
+const (
+	EventUndefined EventType = iota
+	EventPacket
+	EventState
+	EventDigest
+	EventFlapping
+	EventStabilized
+	EventRoam
+	EventTest
+	EventAttempts
+	EventAnomaly
+	EventBandwidth
+)
+
+func (et EventType) String() (str string) {
+	switch et {
+	case EventUndefined:
+		str = "undefined"
+	case EventPacket:
+		str = "packet"
+	case EventState:
+		str = "state"
+	case EventDigest:
+		str = "digest"
+	case EventFlapping:
+		str = "flapping"
+	case EventStabilized:
+		str = "stabilized"
+	case EventRoam:
+		str = "roam"
+	case EventTest:
+		str = "test"
+	case EventAttempts:
+		str = "attempts"
+	case EventAnomaly:
+		str = "anomaly"
+	case EventBandwidth:
+		str = "bandwidth"
+	default:
+		str = "unspecified"
+	}
+	return
+}
+
+func (et EventType) MarshalText() ([]byte, error) {
+	return []byte(et.String()), nil
+}
+
+func (et *EventType) UnmarshalText(text []byte) error {
+	for t := EventUndefined; t.String() != "unspecified"; t++ {
+		if t.String() == string(text) {
+			*et = t
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown event type %q", text)
+}
+
+// Event is a single tracker occurrence delivered to every configured sink.
+type Event struct {
+	Type     EventType              `json:"type"`
+	Time     time.Time              `json:"time"`
+	Device   string                 `json:"device,omitempty"`
+	Peer     string                 `json:"peer,omitempty"`
+	Endpoint string                 `json:"endpoint,omitempty"`
+	State    string                 `json:"state,omitempty"`
+	Packet   *network.PacketDetails `json:"packet,omitempty"`
+	Events   []*Event               `json:"events,omitempty"`
+	Count    int                    `json:"count,omitempty"`
+	Previous string                 `json:"previous_endpoint,omitempty"`
+
+	// peer transfer counters at the time of state change
+	ReceiveBytes  int64 `json:"receive_bytes,omitempty"`
+	TransmitBytes int64 `json:"transmit_bytes,omitempty"`
+	// state changed while wgmon was not running, time is an estimate
+	Missed bool `json:"missed,omitempty"`
+
+	// metadata of the peer from configuration
+	Name  string   `json:"name,omitempty"`
+	Owner string   `json:"owner,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+
+	Attempts []Attempt `json:"attempts,omitempty"`
+	// rule raising anomaly event and its explanation
+	Anomaly string `json:"anomaly,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	// traffic compared with a bandwidth limit
+	Bandwidth *Usage `json:"bandwidth,omitempty"`
+	// geoip location of endpoint when databases are configured
+	Location *geoip.Location `json:"location,omitempty"`
+}
+
+// Attempt summarizes packets of a source which never completed a handshake.
+// Peer is set for sources previously used by a known peer.
+type Attempt struct {
+	Source string    `json:"source"`
+	Class  string    `json:"class"`
+	Count  int       `json:"count"`
+	First  time.Time `json:"first"`
+	Last   time.Time `json:"last"`
+	Device string    `json:"device,omitempty"`
+	Peer   string    `json:"peer,omitempty"`
+	Name   string    `json:"name,omitempty"`
+
+	Location *geoip.Location `json:"location,omitempty"`
+}
+
+func NewPacketEvent(p *network.PacketDetails) *Event {
+	return &Event{
+		Type:     EventPacket,
+		Time:     p.Time,
+		Endpoint: p.RemoteAddr(),
+		Packet:   p,
+	}
+}
+
+func NewStateEvent(device, peer, endpoint, state string) *Event {
+	return &Event{
+		Type:     EventState,
+		Time:     time.Now(),
+		Device:   device,
+		Peer:     peer,
+		Endpoint: endpoint,
+		State:    state,
+	}
+}
+
+// NewDigestEvent aggregates multiple events into a single event.
+func NewDigestEvent(events []*Event) *Event {
+	return &Event{
+		Type:   EventDigest,
+		Time:   time.Now(),
+		Events: events,
+	}
+}
+
+// NewFlappingEvent reports peer that changed state count times in a short
+// period, state holds the latest state of the peer.
+func NewFlappingEvent(last *Event, count int) *Event {
+	return &Event{
+		Type:     EventFlapping,
+		Time:     last.Time,
+		Device:   last.Device,
+		Peer:     last.Peer,
+		Endpoint: last.Endpoint,
+		State:    last.State,
+		Count:    count,
+	}
+}
+
+// NewStabilizedEvent reports previously flapping peer settling in the
+// state of last event.
+func NewStabilizedEvent(last *Event) *Event {
+	return &Event{
+		Type:     EventStabilized,
+		Time:     time.Now(),
+		Device:   last.Device,
+		Peer:     last.Peer,
+		Endpoint: last.Endpoint,
+		State:    last.State,
+	}
+}
+
+// NewRoamEvent reports peer endpoint change.
+func NewRoamEvent(device, peer, previous, endpoint string) *Event {
+	return &Event{
+		Type:     EventRoam,
+		Time:     time.Now(),
+		Device:   device,
+		Peer:     peer,
+		Endpoint: endpoint,
+		Previous: previous,
+	}
+}
+
+// NewTestEvent creates event verifying that notifications are delivered.
+func NewTestEvent() *Event {
+	return &Event{
+		Type: EventTest,
+		Time: time.Now(),
+	}
+}
+
+// NewAttemptsEvent reports failed connection attempts, count holds the
+// number of packets of all sources.
+func NewAttemptsEvent(attempts []Attempt) *Event {
+	e := &Event{
+		Type:     EventAttempts,
+		Time:     time.Now(),
+		Attempts: attempts,
+	}
+	for _, a := range attempts {
+		e.Count += a.Count
+	}
+	return e
+}
+
+// NewAnomalyEvent reports connection e violating anomaly rule.
+func NewAnomalyEvent(e *Event, rule, reason string) *Event {
+	return &Event{
+		Type:     EventAnomaly,
+		Time:     e.Time,
+		Device:   e.Device,
+		Peer:     e.Peer,
+		Endpoint: e.Endpoint,
+		Previous: e.Previous,
+		Anomaly:  rule,
+		Reason:   reason,
+		Location: e.Location,
+	}
+}
+
+// Usage is traffic of a peer, tag or device group compared with a limit.
+// Rates are in bytes per second averaged over Window, totals in bytes
+// transferred since the start of Period.
+type Usage struct {
+	Scope     string        `json:"scope"`
+	Group     string        `json:"group"`
+	Direction string        `json:"direction"`
+	Value     float64       `json:"value"`
+	Limit     float64       `json:"limit"`
+	Window    time.Duration `json:"window,omitempty"`
+	Period    string        `json:"period,omitempty"`
+}
+
+// NewBandwidthEvent reports usage exceeding its limit or getting back
+// within it, device and peer are set for groups of a single peer or device.
+func NewBandwidthEvent(device, peer, state string, u *Usage) *Event {
+	return &Event{
+		Type:      EventBandwidth,
+		Time:      time.Now(),
+		Device:    device,
+		Peer:      peer,
+		State:     state,
+		Bandwidth: u,
+	}
+}
+
+// ID returns connection identifier in the same device:key form as
+// reported by wg.Connection.
+func (e *Event) ID() string {
+	return fmt.Sprintf("%s:%s", e.Device, e.Peer)
+}
+
+// Label returns connection identifier readable by humans, the peer name
+// replaces its key when known.
+func (e *Event) Label() string {
+	if e.Name != "" {
+		return fmt.Sprintf("%s:%s", e.Device, e.Name)
+	}
+	return e.ID()
+}
+
+// endpointLabel returns endpoint followed by its location when known.
+func (e *Event) endpointLabel() string {
+	if e.Location != nil {
+		return fmt.Sprintf("%s (%s)", e.Endpoint, e.Location)
+	}
+	return e.Endpoint
+}
+
+func (e *Event) Message() string {
+	switch e.Type {
+	case EventPacket:
+		p := e.Packet
+		return fmt.Sprintf(
+			MessagePacketFormat,
+			p.Time.Format("2006-01-02 15:04:05 UTC"),
+			"Received packet",
+			p.L4Proto, p.L5Proto, p.RemoteAddr(), p.Destination(),
+		)
+	case EventState:
+		if e.Missed {
+			return fmt.Sprintf(MessageMissedFormat, e.Label(), e.endpointLabel(), e.State, e.Time.UTC().Format("2006-01-02 15:04:05 UTC"))
+		}
+		return fmt.Sprintf(MessageStateFormat, e.Label(), e.endpointLabel(), e.State)
+	case EventDigest:
+		return e.digestMessage()
+	case EventFlapping:
+		return fmt.Sprintf(MessageFlappingFormat, e.Label(), e.endpointLabel(), e.Count)
+	case EventStabilized:
+		return fmt.Sprintf(MessageStabilizedFormat, e.Label(), e.endpointLabel(), e.State)
+	case EventRoam:
+		return fmt.Sprintf(MessageRoamFormat, e.Label(), e.Previous, e.endpointLabel())
+	case EventTest:
+		hostname, _ := os.Hostname()
+		return fmt.Sprintf(MessageTestFormat, hostname)
+	case EventAttempts:
+		return e.attemptsMessage()
+	case EventAnomaly:
+		return fmt.Sprintf(MessageAnomalyFormat, e.Anomaly, e.Label(), e.endpointLabel(), e.Reason)
+	case EventBandwidth:
+		return e.bandwidthMessage()
+	}
+	return fmt.Sprintf("Received %s event", e.Type)
+}
+
+func (e *Event) LogAttrs() []any {
+	switch e.Type {
+	case EventPacket:
+		return []any{"packet", *e.Packet}
+	case EventState:
+		return []any{"endpoint", e.Endpoint, "id", e.ID(), "state", e.State, "missed", e.Missed}
+	case EventStabilized:
+		return []any{"endpoint", e.Endpoint, "id", e.ID(), "state", e.State}
+	case EventFlapping:
+		return []any{"endpoint", e.Endpoint, "id", e.ID(), "state", e.State, "count", e.Count}
+	case EventRoam:
+		return []any{"endpoint", e.Endpoint, "id", e.ID(), "previous", e.Previous}
+	case EventDigest:
+		return []any{"events", len(e.Events), "counts", e.Counts()}
+	case EventAttempts:
+		return []any{"sources", len(e.Attempts), "count", e.Count}
+	case EventAnomaly:
+		return []any{"endpoint", e.Endpoint, "id", e.ID(), "anomaly", e.Anomaly, "reason", e.Reason}
+	case EventBandwidth:
+		if u := e.Bandwidth; u != nil {
+			return []any{"scope", u.Scope, "group", u.Group, "state", e.State, "direction", u.Direction, "value", u.Value, "limit", u.Limit}
+		}
+	}
+	return []any{"type", e.Type}
+}
+
+// Counts returns number of aggregated events per state, or per type for
+// events without state.
+func (e *Event) Counts() map[string]int {
+	counts := make(map[string]int)
+	for _, ev := range e.Events {
+		key := ev.State
+		if key == "" {
+			key = ev.Type.String()
+		}
+		counts[key]++
+	}
+	return counts
+}
+
+func (e *Event) digestMessage() string {
+	if len(e.Events) == 0 {
+		return "Empty digest"
+	}
+
+	counts := e.Counts()
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	summary := make([]string, 0, len(keys))
+	for _, k := range keys {
+		summary = append(summary, fmt.Sprintf("%s: %d", k, counts[k]))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, MessageDigestFormat,
+		len(e.Events),
+		e.Events[0].Time.UTC().Format("2006-01-02 15:04:05"),
+		e.Events[len(e.Events)-1].Time.UTC().Format("15:04:05 UTC"),
+		strings.Join(summary, ", "),
+	)
+	for _, ev := range e.Events {
+		line := ev.Message()
+		switch ev.Type {
+		case EventPacket:
+			line = fmt.Sprintf("Received packet from %s", ev.Endpoint)
+		case EventAttempts:
+			line = strings.TrimSpace(fmt.Sprintf(MessageAttemptsFormat, ev.Count, len(ev.Attempts)))
+		}
+		fmt.Fprintf(&b, "- %s\n", line)
+	}
+	return b.String()
+}
+
+func (e *Event) attemptsMessage() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, MessageAttemptsFormat, e.Count, len(e.Attempts))
+	for _, a := range e.Attempts {
+		source := a.Source
+		if a.Location != nil {
+			source = fmt.Sprintf("%s (%s)", a.Source, a.Location)
+		}
+		fmt.Fprintf(&b, "- %s %s: %d packets from %s to %s", source, a.Class, a.Count,
+			a.First.UTC().Format("2006-01-02 15:04:05"), a.Last.UTC().Format("15:04:05 UTC"))
+		if a.Peer != "" {
+			peer := a.Peer
+			if a.Name != "" {
+				peer = a.Name
+			}
+			fmt.Fprintf(&b, ", last used by %s:%s", a.Device, peer)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func (e *Event) bandwidthMessage() string {
+	u := e.Bandwidth
+	if u == nil {
+		return fmt.Sprintf("Received %s event", e.Type)
+	}
+	group := fmt.Sprintf("%s %s", u.Scope, u.Group)
+	if u.Scope == "peer" {
+		group = "peer " + e.Label()
+	}
+	verb := "exceeded"
+	if e.State != "exceeded" {
+		verb = "is back within"
+	}
+	direction := map[string]string{"receive": "received", "transmit": "transmitted"}[u.Direction]
+	if direction == "" {
+		direction = "in total"
+	}
+	if u.Period != "" {
+		return fmt.Sprintf(MessageTrafficFormat, group, verb,
+			FormatBytes(int64(u.Limit)), direction, u.Period, FormatBytes(int64(u.Value)))
+	}
+	return fmt.Sprintf(MessageBandwidthFormat, group, verb,
+		FormatBytes(int64(u.Limit)), direction, u.Window, FormatBytes(int64(u.Value)))
+}
+
+// FormatBytes formats n with binary units, e.g. "1.50 MiB".
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
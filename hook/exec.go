@@ -0,0 +1,135 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	ExecDefaultTimeout     = 30 * time.Second
+	ExecDefaultConcurrency = 4
+
+	// limit of captured stdout/stderr that ends up in logs
+	execOutputLimit = 4096
+)
+
+// ExecSink runs a local command for every event, similar to wg-quick
+// PostUp hooks. The command is executed through /bin/sh with event
+// details exposed as WGMON_* environment variables and the whole event
+// encoded as JSON on stdin.
+type ExecSink struct {
+	command string
+	timeout time.Duration
+	sem     chan struct{}
+}
+
+func NewExecSink(command string, timeout time.Duration, concurrency int) *ExecSink {
+	if timeout <= 0 {
+		timeout = ExecDefaultTimeout
+	}
+	if concurrency <= 0 {
+		concurrency = ExecDefaultConcurrency
+	}
+	return &ExecSink{
+		command: command,
+		timeout: timeout,
+		sem:     make(chan struct{}, concurrency),
+	}
+}
+
+func (x *ExecSink) Name() string {
+	return "exec"
+}
+
+func (x *ExecSink) Send(e *Event) error {
+	x.sem <- struct{}{}
+	defer func() { <-x.sem }()
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), x.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", x.command)
+	cmd.Env = append(os.Environ(), EventEnv(e)...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err = cmd.Run()
+	attrs := []any{
+		"command", x.command,
+		"event", e.Type,
+		"duration", time.Since(start),
+		"exit", cmd.ProcessState.ExitCode(),
+		"stdout", truncate(stdout.String(), execOutputLimit),
+		"stderr", truncate(stderr.String(), execOutputLimit),
+	}
+	if err != nil {
+		slog.Warn("exec failed", attrs...)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("command timed out after %s", x.timeout)
+		}
+		return err
+	}
+	slog.Info("exec finished", attrs...)
+	return nil
+}
+
+// EventEnv returns event fields as environment variable assignments.
+func EventEnv(e *Event) []string {
+	env := []string{
+		"WGMON_EVENT=" + e.Type.String(),
+		"WGMON_TIME=" + e.Time.UTC().Format(time.RFC3339),
+		"WGMON_DEVICE=" + e.Device,
+		"WGMON_PEER=" + e.Peer,
+		"WGMON_ENDPOINT=" + e.Endpoint,
+		"WGMON_STATE=" + e.State,
+		"WGMON_MESSAGE=" + e.Message(),
+	}
+	if e.Previous != "" {
+		env = append(env, "WGMON_PREVIOUS_ENDPOINT="+e.Previous)
+	}
+	if e.Name != "" {
+		env = append(env, "WGMON_PEER_NAME="+e.Name)
+	}
+	if e.Owner != "" {
+		env = append(env, "WGMON_PEER_OWNER="+e.Owner)
+	}
+	if len(e.Tags) > 0 {
+		env = append(env, "WGMON_PEER_TAGS="+strings.Join(e.Tags, ","))
+	}
+	if e.Anomaly != "" {
+		env = append(env, "WGMON_ANOMALY="+e.Anomaly, "WGMON_REASON="+e.Reason)
+	}
+	if p := e.Packet; p != nil {
+		env = append(env,
+			"WGMON_PACKET_SRC="+p.RemoteAddr(),
+			"WGMON_PACKET_DST="+p.Destination(),
+			"WGMON_PACKET_PROTO="+p.L4Proto,
+		)
+	}
+	return env
+}
+
+func truncate(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
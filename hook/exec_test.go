@@ -0,0 +1,74 @@
+package hook
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecSink(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "env")
+	stdinFile := filepath.Join(dir, "stdin")
+
+	sink := NewExecSink(
+		`echo "$WGMON_EVENT $WGMON_DEVICE $WGMON_PEER $WGMON_STATE" > `+envFile+` && cat > `+stdinFile,
+		5*time.Second, 1,
+	)
+	e := NewStateEvent("wg0", "key", "127.0.0.1:1111", "opened")
+	if err := sink.Send(e); err != nil {
+		t.Fatalf("unexpected send error: %v", err)
+	}
+
+	env, err := os.ReadFile(envFile)
+	if err != nil {
+		t.Fatalf("unable to read env output: %v", err)
+	}
+	if got, want := strings.TrimSpace(string(env)), "state wg0 key opened"; got != want {
+		t.Errorf("unexpected env: got %q, want %q", got, want)
+	}
+
+	stdin, err := os.ReadFile(stdinFile)
+	if err != nil {
+		t.Fatalf("unable to read stdin output: %v", err)
+	}
+	var decoded Event
+	if err := json.Unmarshal(stdin, &decoded); err != nil {
+		t.Fatalf("unable to decode stdin json: %v", err)
+	}
+	if got, want := decoded.Type, EventState; got != want {
+		t.Errorf("unexpected json type: got %v, want %v", got, want)
+	}
+	if got, want := decoded.Endpoint, "127.0.0.1:1111"; got != want {
+		t.Errorf("unexpected json endpoint: got %v, want %v", got, want)
+	}
+}
+
+func TestExecSinkFailure(t *testing.T) {
+	testCases := []struct {
+		name    string
+		command string
+		timeout time.Duration
+	}{
+		{
+			name:    "exit status",
+			command: "exit 3",
+			timeout: time.Second,
+		},
+		{
+			name:    "timeout",
+			command: "sleep 5",
+			timeout: 100 * time.Millisecond,
+		},
+	}
+
+	for i, tc := range testCases {
+		sink := NewExecSink(tc.command, tc.timeout, 1)
+		if err := sink.Send(NewStateEvent("wg0", "key", "", "closed")); err == nil {
+			t.Errorf("case #%d %s, expected error", i, tc.name)
+		}
+	}
+}
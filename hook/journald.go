@@ -0,0 +1,76 @@
+package hook
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const JournaldDefaultSocket = "/run/systemd/journal/socket"
+
+// JournaldSink sends events to journald using its native protocol so
+// event details are stored as separate journal fields (PEER_KEY,
+// ENDPOINT, DEVICE, STATE) and can be queried with journalctl, e.g.
+// journalctl SYSLOG_IDENTIFIER=wgmon PEER_KEY=<key>.
+type JournaldSink struct {
+	socket string
+}
+
+func NewJournaldSink(socket string) *JournaldSink {
+	if socket == "" {
+		socket = JournaldDefaultSocket
+	}
+	return &JournaldSink{socket: socket}
+}
+
+func (j *JournaldSink) Name() string {
+	return "journald"
+}
+
+func (j *JournaldSink) Send(e *Event) error {
+	conn, err := net.Dial("unixgram", j.socket)
+	if err != nil {
+		return fmt.Errorf("failed to connect to journald: %w", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write(JournaldFields(e))
+	return err
+}
+
+// JournaldFields encodes event as journald native protocol datagram.
+func JournaldFields(e *Event) []byte {
+	var buf bytes.Buffer
+	for _, kv := range [][2]string{
+		{"MESSAGE", strings.TrimSpace(e.Message())},
+		{"PRIORITY", strconv.Itoa(syslogSeverityInfo)},
+		{"SYSLOG_IDENTIFIER", syslogAppName},
+		{"WGMON_EVENT", e.Type.String()},
+		{"DEVICE", e.Device},
+		{"PEER_KEY", e.Peer},
+		{"PEER_NAME", e.Name},
+		{"PEER_OWNER", e.Owner},
+		{"ENDPOINT", e.Endpoint},
+		{"STATE", e.State},
+	} {
+		if kv[1] == "" {
+			continue
+		}
+		buf.WriteString(kv[0])
+		if !strings.Contains(kv[1], "\n") {
+			buf.WriteByte('=')
+			buf.WriteString(kv[1])
+			buf.WriteByte('\n')
+			continue
+		}
+		// multiline values are sent as size prefixed binary data
+		buf.WriteByte('\n')
+		binary.Write(&buf, binary.LittleEndian, uint64(len(kv[1])))
+		buf.WriteString(kv[1])
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,73 @@
+package hook
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+var (
+	// go test -v ./hook -args -mqtt tcp://127.0.0.1:1883
+	flagMQTT = flag.String("mqtt", "", "MQTT broker used for live tests")
+)
+
+func TestMQTTTopics(t *testing.T) {
+	s := &MQTTSink{topic: MQTTDefaultTopic}
+	if got, want := s.PeerTopic("wg0", "ab+c/d="), "wgmon/wg0/ab-c_d=/state"; got != want {
+		t.Errorf("unexpected peer topic: got %s, want %s", got, want)
+	}
+	if got, want := s.StatusTopic(), "wgmon/status"; got != want {
+		t.Errorf("unexpected status topic: got %s, want %s", got, want)
+	}
+}
+
+func TestMQTTSink(t *testing.T) {
+	if *flagMQTT == "" {
+		t.SkipNow()
+	}
+
+	sink, err := NewMQTTSink(*flagMQTT + "?topic=wgmontest&qos=1")
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	sub := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(*flagMQTT).SetClientID("wgmon-test-sub"))
+	if err := mqttWait(sub.Connect()); err != nil {
+		t.Fatalf("failed to connect subscriber: %v", err)
+	}
+	defer sub.Disconnect(100)
+
+	e := NewStateEvent("wg0", "a+b/c=", "127.0.0.1:1111", "opened")
+	if err := sink.Send(e); err != nil {
+		t.Fatalf("failed to publish: %v", err)
+	}
+
+	received := make(chan string, 2)
+	handler := func(c mqtt.Client, m mqtt.Message) {
+		received <- string(m.Payload())
+	}
+	// retained values are delivered on subscribe
+	for topic, want := range map[string]string{
+		sink.PeerTopic(e.Device, e.Peer): "opened",
+		sink.StatusTopic():               "online",
+	} {
+		if err := mqttWait(sub.Subscribe(topic, 1, handler)); err != nil {
+			t.Fatalf("failed to subscribe %s: %v", topic, err)
+		}
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("unexpected payload on %s: got %s, want %s", topic, got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("no retained message on %s", topic)
+		}
+		sub.Unsubscribe(topic)
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+}
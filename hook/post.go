@@ -7,40 +7,43 @@ import (
 	"net/url"
 	"strings"
 	"time"
-
-	"github.com/turekt/wgmon/network"
 )
 
 const (
 	MessageStateFormat  = `Connection %s on endpoint %s is %s`
+	MessageMissedFormat = `Connection %s on endpoint %s was %s at %s while wgmon was down`
 	MessagePacketFormat = `%s
 %s
 %s{%s} %s -> %s
 `
+	MessageDigestFormat = `Digest of %d events from %s to %s
+%s
+`
+	MessageFlappingFormat   = `Connection %s on endpoint %s is flapping after %d state changes`
+	MessageStabilizedFormat = `Connection %s on endpoint %s stabilized and is %s`
+	MessageRoamFormat       = `Connection %s roamed from endpoint %s to %s`
+	MessageTestFormat       = `Test notification from wgmon on %s`
+	MessageAttemptsFormat   = `%d failed connection attempts from %d sources
+`
+	MessageAnomalyFormat   = `Anomaly %s on connection %s from endpoint %s: %s`
+	MessageBandwidthFormat = `Bandwidth of %s %s limit of %s/s %s over %s: %s/s`
+	MessageTrafficFormat   = `Traffic of %s %s limit of %s %s this %s: %s`
 )
 
-func PostPacketDetails(webhookUrl string, p *network.PacketDetails) error {
-	slog.Info("packet received", "packet", *p)
-	if webhookUrl == "" {
-		return nil
-	}
+type WebhookSink struct {
+	url string
+}
 
-	msg := fmt.Sprintf(
-		MessagePacketFormat,
-		p.Time.Format("2006-01-02 15:04:05 UTC"),
-		"Received packet",
-		p.L4Proto, p.L5Proto, p.RemoteAddr(), p.Destination(),
-	)
-	return Post(webhookUrl, msg)
+func NewWebhookSink(webhookUrl string) *WebhookSink {
+	return &WebhookSink{url: webhookUrl}
 }
 
-func PostState(webhookUrl, endpoint, id, state string) error {
-	slog.Info("client state change", "endpoint", endpoint, "id", id, "state", state)
-	if webhookUrl == "" {
-		return nil
-	}
+func (w *WebhookSink) Name() string {
+	return "webhook"
+}
 
-	return Post(webhookUrl, fmt.Sprintf(MessageStateFormat, id, endpoint, state))
+func (w *WebhookSink) Send(e *Event) error {
+	return Post(w.url, e.Message())
 }
 
 func Post(webhookUrl, content string) error {
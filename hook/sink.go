@@ -0,0 +1,252 @@
+package hook
+
+import (
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/turekt/wgmon/metrics"
+)
+
+var sinkSends = metrics.Default.Counter(
+	"wgmon_sink_sends_total", "Number of events sent to sinks by result.", "sink", "result")
+
+// CloseTimeout bounds how long closing notifier waits for queued events.
+var CloseTimeout = 10 * time.Second
+
+type Sink interface {
+	Name() string
+	Send(e *Event) error
+}
+
+// Selector is implemented by sinks choosing which event types they receive.
+// Sinks not implementing it receive every event type except opt-in ones.
+type Selector interface {
+	Accepts(t EventType) bool
+}
+
+// optIn are event types delivered only to sinks selecting them, roams are
+// too frequent for notification sinks such as webhook or email.
+var optIn = []EventType{EventRoam}
+
+func accepts(s Sink, t EventType) bool {
+	if sel, ok := s.(Selector); ok {
+		return sel.Accepts(t)
+	}
+	return !slices.Contains(optIn, t)
+}
+
+// Notifier fans out events to all registered sinks. Every sink has its
+// own queue delivering events in notification order, so a slow sink does
+// not block the tracker nor other sinks.
+type Notifier struct {
+	mu      sync.RWMutex
+	queues  []*sinkQueue
+	pending atomic.Int64
+}
+
+func NewNotifier(sinks ...Sink) *Notifier {
+	n := &Notifier{}
+	n.Set(sinks)
+	return n
+}
+
+func (n *Notifier) Add(s Sink) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.queues = append(n.queues, newSinkQueue(s, &n.pending))
+}
+
+func (n *Notifier) Notify(e *Event) {
+	switch e.Type {
+	case EventPacket:
+		slog.Info("packet received", e.LogAttrs()...)
+	case EventState, EventFlapping, EventStabilized, EventRoam, EventAnomaly:
+		slog.Info("client state change", e.LogAttrs()...)
+	default:
+		slog.Info("event", e.LogAttrs()...)
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	for _, q := range n.queues {
+		if accepts(q.sink, e.Type) {
+			q.push(e)
+		}
+	}
+}
+
+// Set replaces all sinks. Sinks kept keep their queues, replaced ones
+// are closed in the background once events queued for them are sent.
+func (n *Notifier) Set(sinks []Sink) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	old := n.queues
+	n.queues = nil
+	for _, s := range sinks {
+		i := slices.IndexFunc(old, func(q *sinkQueue) bool { return q.sink == s })
+		if i < 0 {
+			n.queues = append(n.queues, newSinkQueue(s, &n.pending))
+			continue
+		}
+		n.queues = append(n.queues, old[i])
+		old = slices.Delete(old, i, i+1)
+	}
+	for _, q := range old {
+		go q.shutdown(CloseTimeout)
+	}
+}
+
+// sinkQueue sends events to a sink one at a time, pending counts events
+// queued or being sent.
+type sinkQueue struct {
+	sink    Sink
+	pending *atomic.Int64
+	mu      sync.Mutex
+	events  []*Event
+	closed  bool
+	wake    chan struct{}
+	// done is closed once the queue is closed and drained
+	done chan struct{}
+}
+
+func newSinkQueue(s Sink, pending *atomic.Int64) *sinkQueue {
+	q := &sinkQueue{
+		sink:    s,
+		pending: pending,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// push queues e unless the queue is closed.
+func (q *sinkQueue) push(e *Event) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.events = append(q.events, e)
+	q.pending.Add(1)
+	q.signal()
+}
+
+// close stops the queue once queued events are sent.
+func (q *sinkQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *sinkQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *sinkQueue) run() {
+	defer close(q.done)
+	for range q.wake {
+		for {
+			q.mu.Lock()
+			if len(q.events) == 0 {
+				closed := q.closed
+				q.mu.Unlock()
+				if closed {
+					return
+				}
+				break
+			}
+			e := q.events[0]
+			q.events = q.events[1:]
+			q.mu.Unlock()
+			q.send(e)
+		}
+	}
+}
+
+// shutdown closes the queue and, once queued events are sent or timeout
+// expires, the sink.
+func (q *sinkQueue) shutdown(timeout time.Duration) {
+	q.close()
+	select {
+	case <-q.done:
+	case <-time.After(timeout):
+		slog.Error("sink close timed out, dropping queued events", "sink", q.sink.Name())
+	}
+	if c, ok := q.sink.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			slog.Error("sink close error", "sink", q.sink.Name(), "error", err)
+		}
+	}
+}
+
+func (q *sinkQueue) send(e *Event) {
+	defer q.pending.Add(-1)
+	if err := q.sink.Send(e); err != nil {
+		sinkSends.With(q.sink.Name(), "failure").Inc()
+		slog.Error("sink send error", "sink", q.sink.Name(), "event", e.Type, "error", err)
+		return
+	}
+	sinkSends.With(q.sink.Name(), "success").Inc()
+}
+
+// SendResult is an outcome of sending an event to a single sink.
+type SendResult struct {
+	Sink  string `json:"sink"`
+	Error string `json:"error,omitempty"`
+}
+
+// Test sends e to every sink and waits for the results.
+func (n *Notifier) Test(e *Event) []SendResult {
+	slog.Info("sending test notification")
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	results := make([]SendResult, len(n.queues))
+	var wg sync.WaitGroup
+	for i, q := range n.queues {
+		s := q.sink
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i].Sink = s.Name()
+			if err := s.Send(e); err != nil {
+				sinkSends.With(s.Name(), "failure").Inc()
+				results[i].Error = err.Error()
+				return
+			}
+			sinkSends.With(s.Name(), "success").Inc()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// Pending returns number of sends which have not finished yet.
+func (n *Notifier) Pending() int {
+	return int(n.pending.Load())
+}
+
+// Close releases sinks holding connections open after sending events
+// queued for them, waiting at most CloseTimeout.
+func (n *Notifier) Close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, q := range n.queues {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.shutdown(CloseTimeout)
+		}()
+	}
+	wg.Wait()
+}
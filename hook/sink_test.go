@@ -0,0 +1,241 @@
+package hook
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type blockingSink struct {
+	release chan struct{}
+}
+
+func (s *blockingSink) Name() string {
+	return "blocking"
+}
+
+func (s *blockingSink) Send(e *Event) error {
+	<-s.release
+	return nil
+}
+
+func TestNotifierPending(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	n := NewNotifier(sink, sink)
+	n.Notify(NewStateEvent("wg0", "a=", "", "opened"))
+	n.Notify(NewStateEvent("wg0", "a=", "", "closed"))
+	if got, want := n.Pending(), 4; got != want {
+		t.Errorf("unexpected pending sends: got %d, want %d", got, want)
+	}
+
+	close(sink.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for n.Pending() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got, want := n.Pending(), 0; got != want {
+		t.Errorf("unexpected pending sends after release: got %d, want %d", got, want)
+	}
+}
+
+func TestNotifierOrder(t *testing.T) {
+	rec := &recordSink{}
+	blocking := &blockingSink{release: make(chan struct{})}
+	n := NewNotifier(rec, blocking)
+	states := []string{"opened", "closed", "opened", "closed", "opened"}
+	for _, state := range states {
+		n.Notify(NewStateEvent("wg0", "a=", "", state))
+	}
+
+	// blocked sink does not hold back others
+	deadline := time.Now().Add(5 * time.Second)
+	for len(rec.Events()) < len(states) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	var got []string
+	for _, e := range rec.Events() {
+		got = append(got, e.State)
+	}
+	if !slices.Equal(got, states) {
+		t.Errorf("unexpected order: got %v, want %v", got, states)
+	}
+	if got, want := n.Pending(), len(states); got != want {
+		t.Errorf("unexpected pending sends: got %d, want %d", got, want)
+	}
+	close(blocking.release)
+}
+
+func TestNotifierOptIn(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, string(body))
+	}))
+	defer srv.Close()
+
+	out := filepath.Join(t.TempDir(), "events")
+	rec := &recordSink{}
+	n := NewNotifier(NewWebhookSink(srv.URL), NewExecSink(`echo "$WGMON_EVENT" >> `+out, 5*time.Second, 1), rec)
+	n.Notify(NewRoamEvent("wg0", "a=", "1.1.1.1:1", "2.2.2.2:2"))
+	n.Notify(NewStateEvent("wg0", "a=", "2.2.2.2:2", "closed"))
+	deadline := time.Now().Add(5 * time.Second)
+	for n.Pending() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// roams are delivered only to sinks selecting them
+	mu.Lock()
+	defer mu.Unlock()
+	if got, want := len(received), 1; got != want || strings.Contains(received[0], "roamed") {
+		t.Errorf("unexpected webhook requests: got %q, want %d without roam", received, want)
+	}
+	if data, err := os.ReadFile(out); err != nil || string(data) != "state\n" {
+		t.Errorf("unexpected exec events: got %q, %v, want state only", data, err)
+	}
+	if got, want := len(rec.Events()), 1; got != want {
+		t.Errorf("unexpected delivered events: got %d, want %d", got, want)
+	}
+	if !accepts(&selectSink{}, EventRoam) {
+		t.Error("selecting sink should receive roams")
+	}
+}
+
+type selectSink struct {
+	recordSink
+}
+
+func (s *selectSink) Accepts(t EventType) bool {
+	return true
+}
+
+// closingSink sends slowly and remembers events sent before it was closed.
+type closingSink struct {
+	mu     sync.Mutex
+	sent   int
+	closed bool
+	late   int
+}
+
+func (s *closingSink) Name() string {
+	return "closing"
+}
+
+func (s *closingSink) Send(e *Event) error {
+	time.Sleep(20 * time.Millisecond)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		s.late++
+	}
+	s.sent++
+	return nil
+}
+
+func (s *closingSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestNotifierClose(t *testing.T) {
+	sink := &closingSink{}
+	n := NewNotifier(sink)
+	for range 5 {
+		n.Notify(NewStateEvent("wg0", "a=", "", "opened"))
+	}
+	n.Close()
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if !sink.closed {
+		t.Fatal("sink not closed")
+	}
+	if got, want := sink.sent, 5; got != want || sink.late > 0 {
+		t.Errorf("unexpected events sent before close: got %d, want %d, %d after close", got-sink.late, want, sink.late)
+	}
+	if got, want := n.Pending(), 0; got != want {
+		t.Errorf("unexpected pending sends: got %d, want %d", got, want)
+	}
+}
+
+func TestNotifierSet(t *testing.T) {
+	replaced, kept := &closingSink{}, &closingSink{}
+	n := NewNotifier(replaced, kept)
+	for range 3 {
+		n.Notify(NewStateEvent("wg0", "a=", "", "opened"))
+	}
+	n.Set([]Sink{kept})
+	n.Notify(NewStateEvent("wg0", "a=", "", "closed"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for n.Pending() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// replaced sink is closed after its queued events are sent
+	for !func() bool {
+		replaced.mu.Lock()
+		defer replaced.mu.Unlock()
+		return replaced.closed
+	}() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	testCases := []struct {
+		sink   *closingSink
+		sent   int
+		closed bool
+	}{
+		{replaced, 3, true},
+		{kept, 4, false},
+	}
+	for i, tc := range testCases {
+		tc.sink.mu.Lock()
+		if tc.sink.sent != tc.sent || tc.sink.closed != tc.closed || tc.sink.late > 0 {
+			t.Errorf("case #%d, unexpected sink: got %d sent, closed %v, want %d sent, closed %v",
+				i, tc.sink.sent, tc.sink.closed, tc.sent, tc.closed)
+		}
+		tc.sink.mu.Unlock()
+	}
+}
+
+type failingSink struct{}
+
+func (s *failingSink) Name() string {
+	return "failing"
+}
+
+func (s *failingSink) Send(e *Event) error {
+	return errors.New("unreachable")
+}
+
+func TestNotifierTest(t *testing.T) {
+	rec := &recordSink{}
+	n := NewNotifier(NewDigestSink(rec, time.Hour, 0, nil), &failingSink{})
+	results := n.Test(NewTestEvent())
+
+	if got, want := len(results), 2; got != want {
+		t.Fatalf("unexpected result count: got %d, want %d", got, want)
+	}
+	if got, want := results[0].Error, ""; got != want {
+		t.Errorf("unexpected digest sink error: got %q, want %q", got, want)
+	}
+	if got, want := results[1].Error, "unreachable"; got != want {
+		t.Errorf("unexpected failing sink error: got %q, want %q", got, want)
+	}
+	// test events are never held back by digest
+	if got, want := len(rec.Events()), 1; got != want {
+		t.Errorf("unexpected delivered events: got %d, want %d", got, want)
+	}
+}
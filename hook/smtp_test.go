@@ -0,0 +1,193 @@
+package hook
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/mail"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type smtpMock struct {
+	listener net.Listener
+	rcpts    chan []string
+	data     chan string
+}
+
+func newSMTPMock() (*smtpMock, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	m := &smtpMock{listener: l, rcpts: make(chan []string, 8), data: make(chan string, 8)}
+	go m.serve()
+	return m, nil
+}
+
+func (m *smtpMock) serve() {
+	for {
+		conn, err := m.listener.Accept()
+		if err != nil {
+			return
+		}
+		m.handle(conn)
+	}
+}
+
+func (m *smtpMock) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "220 mock\r\n")
+	var rcpts []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.Fields(line)[0])
+		switch cmd {
+		case "EHLO", "HELO", "MAIL":
+			fmt.Fprint(conn, "250 ok\r\n")
+		case "RCPT":
+			rcpts = append(rcpts, strings.Trim(strings.TrimPrefix(strings.TrimSpace(line), "RCPT TO:"), "<>"))
+			fmt.Fprint(conn, "250 ok\r\n")
+		case "DATA":
+			fmt.Fprint(conn, "354 go\r\n")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil || l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			m.rcpts <- rcpts
+			m.data <- data.String()
+			fmt.Fprint(conn, "250 queued\r\n")
+		case "QUIT":
+			fmt.Fprint(conn, "221 bye\r\n")
+			return
+		default:
+			fmt.Fprint(conn, "502 unsupported\r\n")
+		}
+	}
+}
+
+func TestParseMailRoutes(t *testing.T) {
+	routes, err := ParseMailRoutes("ops@example.com, alice@example.com?device=wg0&state=opened&state=closed")
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+	if got, want := len(routes), 2; got != want {
+		t.Fatalf("unexpected route count: got %d, want %d", got, want)
+	}
+
+	testCases := []struct {
+		name   string
+		event  *Event
+		expect []bool
+	}{
+		{"opened wg0", NewStateEvent("wg0", "key", "", "opened"), []bool{true, true}},
+		{"closed wg1", NewStateEvent("wg1", "key", "", "closed"), []bool{true, false}},
+		{"other state", NewStateEvent("wg0", "key", "", "flapping"), []bool{true, false}},
+	}
+	for i, tc := range testCases {
+		for j, r := range routes {
+			if got, want := r.Match(tc.event), tc.expect[j]; got != want {
+				t.Errorf("case #%d %s route %s, unexpected match: got %v, want %v", i, tc.name, r.Recipient, got, want)
+			}
+		}
+	}
+
+	for _, invalid := range []string{"", "not-an-address", "Alice <alice@example.com>"} {
+		if _, err := ParseMailRoutes(invalid); err == nil {
+			t.Errorf("expected error for recipients %q", invalid)
+		}
+	}
+}
+
+func TestMailSink(t *testing.T) {
+	mock, err := newSMTPMock()
+	if err != nil {
+		t.Fatalf("unable to start smtp mock: %v", err)
+	}
+	defer mock.listener.Close()
+
+	sink, err := NewMailSink(
+		"smtp://"+mock.listener.Addr().String()+"?from=wgmon@example.com",
+		"ops@example.com,alice@example.com?state=closed",
+	)
+	if err != nil {
+		t.Fatalf("unable to create sink: %v", err)
+	}
+
+	if err := sink.Send(NewStateEvent("wg0", "key", "127.0.0.1:1111", "opened")); err != nil {
+		t.Fatalf("unexpected send error: %v", err)
+	}
+	if got, want := <-mock.rcpts, []string{"ops@example.com"}; !slices.Equal(got, want) {
+		t.Errorf("unexpected recipients: got %v, want %v", got, want)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(<-mock.data))
+	if err != nil {
+		t.Fatalf("unable to parse sent message: %v", err)
+	}
+	if got, want := msg.Header.Get("Subject"), "[wgmon] wg0:key opened"; got != want {
+		t.Errorf("unexpected subject: got %q, want %q", got, want)
+	}
+	if got := msg.Header.Get("Content-Type"); !strings.HasPrefix(got, "multipart/alternative") {
+		t.Errorf("unexpected content type: got %q", got)
+	}
+}
+
+func TestMailSinkDigest(t *testing.T) {
+	mock, err := newSMTPMock()
+	if err != nil {
+		t.Fatalf("unable to start smtp mock: %v", err)
+	}
+	defer mock.listener.Close()
+
+	sink, err := NewMailSink(
+		"smtp://"+mock.listener.Addr().String()+"?from=wgmon@example.com",
+		"ops@example.com,alice@example.com?device=wg1&state=closed,bob@example.com?device=wg2",
+	)
+	if err != nil {
+		t.Fatalf("unable to create sink: %v", err)
+	}
+
+	digest := NewDigestEvent([]*Event{
+		NewStateEvent("wg0", "key", "127.0.0.1:1111", "opened"),
+		NewStateEvent("wg1", "key", "127.0.0.1:2222", "closed"),
+		NewStateEvent("wg1", "key", "127.0.0.1:3333", "opened"),
+	})
+	if err := sink.Send(digest); err != nil {
+		t.Fatalf("unexpected send error: %v", err)
+	}
+
+	// every recipient gets only events matching its filters
+	testCases := []struct {
+		rcpt    string
+		subject string
+	}{
+		{"ops@example.com", "[wgmon] digest of 3 events"},
+		{"alice@example.com", "[wgmon] wg1:key closed"},
+	}
+	for i, tc := range testCases {
+		if got, want := <-mock.rcpts, []string{tc.rcpt}; !slices.Equal(got, want) {
+			t.Errorf("case #%d, unexpected recipients: got %v, want %v", i, got, want)
+		}
+		msg, err := mail.ReadMessage(strings.NewReader(<-mock.data))
+		if err != nil {
+			t.Fatalf("case #%d, unable to parse sent message: %v", i, err)
+		}
+		if got := msg.Header.Get("Subject"); got != tc.subject {
+			t.Errorf("case #%d, unexpected subject: got %q, want %q", i, got, tc.subject)
+		}
+	}
+	if len(mock.rcpts) > 0 {
+		t.Errorf("unexpected message to %v", <-mock.rcpts)
+	}
+}
@@ -0,0 +1,173 @@
+package hook
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SyslogDefaultSocket = "/dev/log"
+
+	// private enterprise number reserved for documentation (RFC 5612)
+	syslogEnterpriseID = 32473
+	syslogAppName      = "wgmon"
+	syslogSeverityInfo = 6
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// SyslogSink writes RFC 5424 formatted events to a syslog server.
+// Supported addresses are udp://host:port, tcp://host:port,
+// tls://host:port and unix:///path; an empty address selects the local
+// /dev/log socket. Stream transports use octet counting framing (RFC 6587).
+// The facility can be changed with ?facility=local0 and tls transport
+// accepts ?ca=/path/to/ca.pem for custom roots.
+type SyslogSink struct {
+	mu       sync.Mutex
+	network  string
+	addr     string
+	tls      *tls.Config
+	facility int
+	hostname string
+	conn     net.Conn
+}
+
+func NewSyslogSink(address string) (*SyslogSink, error) {
+	if address == "" {
+		address = "unix://" + SyslogDefaultSocket
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse syslog address %s: %w", address, err)
+	}
+
+	hostname, _ := os.Hostname()
+	s := &SyslogSink{
+		facility: syslogFacilities["daemon"],
+		hostname: hostname,
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp":
+		s.network, s.addr = u.Scheme, u.Host
+	case "tls":
+		s.network, s.addr = "tcp", u.Host
+		if s.tls, err = newTLSConfig(u.Hostname(), u.Query().Get("ca")); err != nil {
+			return nil, err
+		}
+	case "unix", "unixgram":
+		s.network, s.addr = "unixgram", u.Path
+	default:
+		return nil, fmt.Errorf("unsupported syslog scheme %q", u.Scheme)
+	}
+
+	if f := u.Query().Get("facility"); f != "" {
+		facility, ok := syslogFacilities[f]
+		if !ok {
+			return nil, fmt.Errorf("unknown syslog facility %q", f)
+		}
+		s.facility = facility
+	}
+
+	return s, nil
+}
+
+func (s *SyslogSink) Name() string {
+	return "syslog"
+}
+
+func (s *SyslogSink) Send(e *Event) error {
+	msg := s.Format(e)
+	if s.network == "tcp" {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// retry once with a fresh connection in case server went away
+	var err error
+	for range 2 {
+		if s.conn == nil {
+			if s.conn, err = s.dial(); err != nil {
+				return err
+			}
+		}
+		if _, err = s.conn.Write([]byte(msg)); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+// Close releases connection to syslog server, a later Send reconnects.
+func (s *SyslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+func (s *SyslogSink) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if s.tls != nil {
+		return tls.DialWithDialer(dialer, s.network, s.addr, s.tls)
+	}
+	return dialer.Dial(s.network, s.addr)
+}
+
+// Format renders the event as RFC 5424 message with event fields placed in
+// structured data.
+func (s *SyslogSink) Format(e *Event) string {
+	hostname := s.hostname
+	if hostname == "" {
+		hostname = "-"
+	}
+	sd := fmt.Sprintf("[wgmon@%d", syslogEnterpriseID)
+	for _, kv := range [][2]string{
+		{"type", e.Type.String()},
+		{"device", e.Device},
+		{"peer", e.Peer},
+		{"name", e.Name},
+		{"owner", e.Owner},
+		{"endpoint", e.Endpoint},
+		{"state", e.State},
+	} {
+		if kv[1] != "" {
+			sd += fmt.Sprintf(" %s=\"%s\"", kv[0], syslogEscape(kv[1]))
+		}
+	}
+	sd += "]"
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		s.facility*8+syslogSeverityInfo,
+		e.Time.UTC().Format(time.RFC3339Nano),
+		hostname,
+		syslogAppName,
+		os.Getpid(),
+		e.Type,
+		sd,
+		strings.TrimSpace(e.Message()),
+	)
+}
+
+func syslogEscape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(v)
+}
@@ -0,0 +1,128 @@
+package hook
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/turekt/wgmon/network"
+)
+
+func TestSyslogFormat(t *testing.T) {
+	sink, err := NewSyslogSink("udp://127.0.0.1:514?facility=local0")
+	if err != nil {
+		t.Fatalf("unable to create sink: %v", err)
+	}
+	sink.hostname = "host"
+
+	e := NewStateEvent("wg0", `k"y]`, "127.0.0.1:1111", "opened")
+	msg := sink.Format(e)
+	if got, want := msg[:len("<134>1 ")], "<134>1 "; got != want {
+		t.Errorf("unexpected priority: got %q, want %q", got, want)
+	}
+	if want := ` host wgmon `; !strings.Contains(msg, want) {
+		t.Errorf("missing host and app %q in %q", want, msg)
+	}
+	if want := `[wgmon@32473 type="state" device="wg0" peer="k\"y\]" endpoint="127.0.0.1:1111" state="opened"]`; !strings.Contains(msg, want) {
+		t.Errorf("missing structured data %q in %q", want, msg)
+	}
+}
+
+func TestSyslogSend(t *testing.T) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen udp: %v", err)
+	}
+	defer udp.Close()
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen tcp: %v", err)
+	}
+	defer tcp.Close()
+
+	sock := filepath.Join(t.TempDir(), "log")
+	unix, err := net.ListenPacket("unixgram", sock)
+	if err != nil {
+		t.Fatalf("unable to listen unix: %v", err)
+	}
+	defer unix.Close()
+
+	e := NewStateEvent("wg0", "key", "127.0.0.1:1111", "closed")
+	read := map[string]func() (string, error){
+		"udp://" + udp.LocalAddr().String(): func() (string, error) {
+			buf := make([]byte, 2048)
+			n, _, err := udp.ReadFrom(buf)
+			return string(buf[:n]), err
+		},
+		"unix://" + sock: func() (string, error) {
+			buf := make([]byte, 2048)
+			n, _, err := unix.ReadFrom(buf)
+			return string(buf[:n]), err
+		},
+		"tcp://" + tcp.Addr().String(): func() (string, error) {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return "", err
+			}
+			defer conn.Close()
+			var n int
+			r := bufio.NewReader(conn)
+			if _, err := fmt.Fscanf(r, "%d ", &n); err != nil {
+				return "", err
+			}
+			buf := make([]byte, n)
+			_, err = r.Read(buf)
+			return string(buf), err
+		},
+	}
+
+	for addr, readFunc := range read {
+		sink, err := NewSyslogSink(addr)
+		if err != nil {
+			t.Fatalf("unable to create sink %s: %v", addr, err)
+		}
+		if err := sink.Send(e); err != nil {
+			t.Fatalf("unable to send to %s: %v", addr, err)
+		}
+		msg, err := readFunc()
+		if err != nil {
+			t.Fatalf("unable to read from %s: %v", addr, err)
+		}
+		if got, want := msg, sink.Format(e); got != want {
+			t.Errorf("unexpected message on %s: got %q, want %q", addr, got, want)
+		}
+
+		// released by notifier and configuration reload
+		closer, ok := any(sink).(io.Closer)
+		if !ok {
+			t.Fatalf("sink %s does not release its connection", addr)
+		}
+		if err := closer.Close(); err != nil {
+			t.Errorf("unable to close %s: %v", addr, err)
+		}
+		if sink.conn != nil {
+			t.Errorf("connection to %s left open", addr)
+		}
+	}
+}
+
+func TestJournaldFields(t *testing.T) {
+	e := NewStateEvent("wg0", "key", "127.0.0.1:1111", "opened")
+	fields := JournaldFields(e)
+	for _, want := range []string{"DEVICE=wg0\n", "PEER_KEY=key\n", "ENDPOINT=127.0.0.1:1111\n", "STATE=opened\n", "SYSLOG_IDENTIFIER=wgmon\n"} {
+		if !bytes.Contains(fields, []byte(want)) {
+			t.Errorf("missing journald field %q in %q", want, fields)
+		}
+	}
+
+	p := NewPacketEvent(&network.PacketDetails{SrcIP: "127.0.0.1", SrcPort: "1111"})
+	if want := []byte("MESSAGE\n"); !bytes.HasPrefix(JournaldFields(p), want) {
+		t.Errorf("expected binary encoded multiline message, got %q", JournaldFields(p))
+	}
+}
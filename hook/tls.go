@@ -0,0 +1,27 @@
+package hook
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// newTLSConfig returns client TLS config verifying the server against
+// system roots or, if provided, against certificates from caFile.
+func newTLSConfig(serverName, caFile string) (*tls.Config, error) {
+	config := &tls.Config{ServerName: serverName}
+	if caFile == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ca %s: %w", caFile, err)
+	}
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in ca %s", caFile)
+	}
+	return config, nil
+}
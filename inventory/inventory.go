@@ -0,0 +1,232 @@
+// Package inventory collects human readable peer metadata from WireGuard
+// configuration files, an inventory file and wgmon configuration, and keeps
+// it up to date when the files change.
+package inventory
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/turekt/wgmon/wg"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"gopkg.in/yaml.v3"
+)
+
+// WatchInterval is how often watched files are checked for changes.
+const WatchInterval = 10 * time.Second
+
+// Entry is metadata of a single peer. Entries without device apply to the
+// key on every device.
+type Entry struct {
+	PublicKey string   `yaml:"public_key"`
+	Device    string   `yaml:"device"`
+	Name      string   `yaml:"name"`
+	Owner     string   `yaml:"owner"`
+	Tags      []string `yaml:"tags"`
+}
+
+// ID returns entry identifier keyed for wg.Tracker.SetPeers.
+func (e *Entry) ID() string {
+	if e.Device == "" {
+		return e.PublicKey
+	}
+	return e.Device + ":" + e.PublicKey
+}
+
+// ParseFile decodes inventory file, a YAML list of entries.
+func ParseFile(data []byte) ([]Entry, error) {
+	var entries []Entry
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&entries); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	for i, e := range entries {
+		if _, err := wgtypes.ParseKey(e.PublicKey); err != nil {
+			return nil, fmt.Errorf("entry %d: invalid public key %q", i, e.PublicKey)
+		}
+	}
+	return entries, nil
+}
+
+// Merge combines entries into peer metadata, later layers take precedence.
+// Fields are merged one by one, so a layer may set only the owner of a peer
+// named by a previous one.
+func Merge(layers ...[]Entry) map[string]wg.PeerInfo {
+	peers := make(map[string]wg.PeerInfo)
+	apply := func(id string, e *Entry) {
+		info := peers[id]
+		if e.Name != "" {
+			info.Name = e.Name
+		}
+		if e.Owner != "" {
+			info.Owner = e.Owner
+		}
+		if len(e.Tags) > 0 {
+			info.Tags = e.Tags
+		}
+		peers[id] = info
+	}
+	for _, layer := range layers {
+		for _, e := range layer {
+			id := e.ID()
+			if _, ok := peers[id]; !ok && e.Device != "" {
+				// device entry inherits what is known about the key
+				if info, ok := peers[e.PublicKey]; ok {
+					peers[id] = info
+				}
+			}
+			apply(id, &e)
+			if e.Device == "" {
+				for k := range peers {
+					if strings.HasSuffix(k, ":"+e.PublicKey) {
+						apply(k, &e)
+					}
+				}
+			}
+		}
+	}
+	return peers
+}
+
+// Inventory loads peer metadata from WireGuard configuration files matching
+// a glob pattern and an inventory file, overridden by static entries.
+type Inventory struct {
+	mu     sync.Mutex
+	confs  string
+	file   string
+	static []Entry
+	// modification times of files at the last load
+	mtimes map[string]time.Time
+}
+
+func New(confs, file string, static []Entry) *Inventory {
+	return &Inventory{confs: confs, file: file, static: static}
+}
+
+// Set replaces sources, the next Changed reports a change.
+func (i *Inventory) Set(confs, file string, static []Entry) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.confs, i.file, i.static = confs, file, static
+	i.mtimes = nil
+}
+
+// files returns watched files with their modification times, missing
+// inventory file is left out.
+func (i *Inventory) files() (map[string]time.Time, error) {
+	var paths []string
+	if i.confs != "" {
+		matches, err := filepath.Glob(i.confs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid wireguard config pattern %q: %w", i.confs, err)
+		}
+		paths = append(paths, matches...)
+	}
+	if i.file != "" {
+		paths = append(paths, i.file)
+	}
+	mtimes := make(map[string]time.Time, len(paths))
+	for _, p := range paths {
+		if fi, err := os.Stat(p); err == nil {
+			mtimes[p] = fi.ModTime()
+		}
+	}
+	return mtimes, nil
+}
+
+// Changed reports whether any file was added, removed or modified since
+// the last load.
+func (i *Inventory) Changed() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	mtimes, err := i.files()
+	if err != nil || i.mtimes == nil {
+		return true
+	}
+	if len(mtimes) != len(i.mtimes) {
+		return true
+	}
+	for p, t := range mtimes {
+		if prev, ok := i.mtimes[p]; !ok || !prev.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Load reads all sources. Files failing to parse are reported in error and
+// skipped, metadata from the rest is returned.
+func (i *Inventory) Load() (map[string]wg.PeerInfo, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	mtimes, err := i.files()
+	if err != nil {
+		return Merge(i.static), err
+	}
+	i.mtimes = mtimes
+
+	var errs []error
+	var confs, file []Entry
+	paths := make([]string, 0, len(mtimes))
+	for p := range mtimes {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+	for _, p := range paths {
+		if p == i.file {
+			continue
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		device := strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))
+		entries, err := ParseConf(device, f)
+		f.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p, err))
+			continue
+		}
+		confs = append(confs, entries...)
+	}
+	if i.file != "" {
+		data, err := os.ReadFile(i.file)
+		if err == nil {
+			file, err = ParseFile(data)
+			if err != nil {
+				err = fmt.Errorf("%s: %w", i.file, err)
+			}
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return Merge(confs, file, i.static), errors.Join(errs...)
+}
+
+// Watch checks sources every interval and calls apply with metadata
+// reloaded after a change.
+func (i *Inventory) Watch(interval time.Duration, apply func(map[string]wg.PeerInfo)) {
+	for range time.Tick(interval) {
+		if !i.Changed() {
+			continue
+		}
+		peers, err := i.Load()
+		if err != nil {
+			slog.Error("inventory reload incomplete", "error", err)
+		}
+		apply(peers)
+		slog.Info("inventory reloaded", "peers", len(peers))
+	}
+}
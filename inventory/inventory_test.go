@@ -0,0 +1,132 @@
+package inventory
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	keyA = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+	keyB = "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="
+)
+
+const testConf = `[Interface]
+# Name = server
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+ListenPort = 51820
+
+[Peer]
+# Name = alice-laptop
+#Owner: alice@example.com
+# tags = laptop, staff
+PublicKey = ` + keyA + `
+AllowedIPs = 10.0.0.2/32
+
+[Peer]
+PublicKey = ` + keyB + `
+# friendly_name = bob-phone
+# this is a plain comment
+AllowedIPs = 10.0.0.3/32
+`
+
+func TestParseConf(t *testing.T) {
+	entries, err := ParseConf("wg0", strings.NewReader(testConf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(entries), 2; got != want {
+		t.Fatalf("unexpected entries: got %d, want %d", got, want)
+	}
+	a := entries[0]
+	if a.ID() != "wg0:"+keyA || a.Name != "alice-laptop" || a.Owner != "alice@example.com" {
+		t.Errorf("unexpected entry: %+v", a)
+	}
+	if got, want := a.Tags, []string{"laptop", "staff"}; !slices.Equal(got, want) {
+		t.Errorf("unexpected tags: got %v, want %v", got, want)
+	}
+	if got, want := entries[1].Name, "bob-phone"; got != want {
+		t.Errorf("unexpected name: got %v, want %v", got, want)
+	}
+
+	_, err = ParseConf("wg0", strings.NewReader("[Peer]\n# Name = x\nPublicKey = abc\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("expected invalid key error, got %v", err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	confs := []Entry{
+		{PublicKey: keyA, Device: "wg0", Name: "alice-laptop", Tags: []string{"laptop"}},
+		{PublicKey: keyB, Device: "wg0", Name: "bob-phone"},
+	}
+	file := []Entry{
+		{PublicKey: keyA, Owner: "alice@example.com"},
+	}
+	static := []Entry{
+		{PublicKey: keyB, Device: "wg1", Owner: "bob@example.com"},
+		{PublicKey: keyB, Name: "bob"},
+	}
+	peers := Merge(confs, file, static)
+
+	a := peers["wg0:"+keyA]
+	if a.Name != "alice-laptop" || a.Owner != "alice@example.com" || !slices.Equal(a.Tags, []string{"laptop"}) {
+		t.Errorf("unexpected alice: %+v", a)
+	}
+	if got, want := peers["wg0:"+keyB].Name, "bob"; got != want {
+		t.Errorf("unexpected bob name: got %v, want %v", got, want)
+	}
+	if b := peers["wg1:"+keyB]; b.Name != "bob" || b.Owner != "bob@example.com" {
+		t.Errorf("unexpected bob on wg1: %+v", b)
+	}
+}
+
+func TestInventory(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("wg0.conf", testConf)
+	write("inventory.yaml", "- public_key: "+keyB+"\n  owner: bob@example.com\n")
+
+	inv := New(filepath.Join(dir, "*.conf"), filepath.Join(dir, "inventory.yaml"), nil)
+	if !inv.Changed() {
+		t.Error("inventory should change before first load")
+	}
+	peers, err := inv.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := peers["wg0:"+keyB]; b.Name != "bob-phone" || b.Owner != "bob@example.com" {
+		t.Errorf("unexpected bob: %+v", b)
+	}
+	if inv.Changed() {
+		t.Error("inventory should not change after load")
+	}
+
+	// broken file is reported while the rest still loads
+	write("wg1.conf", "[Peer]\nPublicKey = abc\n")
+	if !inv.Changed() {
+		t.Error("inventory should change after file is added")
+	}
+	peers, err = inv.Load()
+	if err == nil {
+		t.Error("expected error of broken config")
+	}
+	if got, want := peers["wg0:"+keyA].Name, "alice-laptop"; got != want {
+		t.Errorf("unexpected name: got %v, want %v", got, want)
+	}
+
+	os.Remove(filepath.Join(dir, "wg1.conf"))
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "wg0.conf"), later, later)
+	if !inv.Changed() {
+		t.Error("inventory should change after file is modified")
+	}
+}
@@ -0,0 +1,98 @@
+package inventory
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// ParseConf reads peers of a WireGuard configuration file of device along
+// with their comment annotations, e.g.
+//
+//	[Peer]
+//	# Name = alice-laptop
+//	# Owner = alice@example.com
+//	# Tags = laptop, staff
+//	PublicKey = ...
+//
+// Annotations belong to the section they are written in, keys are case
+// insensitive and friendly_name is accepted as name. Peers without any
+// annotation are left out.
+func ParseConf(device string, r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	var peer *Entry
+	var peerLine int
+	flush := func() error {
+		if peer == nil {
+			return nil
+		}
+		if _, err := wgtypes.ParseKey(peer.PublicKey); err != nil {
+			return fmt.Errorf("peer at line %d: invalid public key %q", peerLine, peer.PublicKey)
+		}
+		if peer.Name != "" || peer.Owner != "" || len(peer.Tags) > 0 {
+			entries = append(entries, *peer)
+		}
+		peer = nil
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(text, "["):
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			if strings.EqualFold(text, "[Peer]") {
+				peer, peerLine = &Entry{Device: device}, line
+			}
+		case peer == nil:
+		case strings.HasPrefix(text, "#"):
+			key, value, ok := annotation(strings.TrimLeft(text, "# \t"))
+			if !ok {
+				continue
+			}
+			switch strings.ToLower(key) {
+			case "name", "friendly_name":
+				peer.Name = value
+			case "owner":
+				peer.Owner = value
+			case "tags":
+				for _, tag := range strings.Split(value, ",") {
+					if tag = strings.TrimSpace(tag); tag != "" {
+						peer.Tags = append(peer.Tags, tag)
+					}
+				}
+			}
+		default:
+			key, value, ok := strings.Cut(text, "=")
+			if ok && strings.EqualFold(strings.TrimSpace(key), "PublicKey") {
+				peer.PublicKey = strings.TrimSpace(value)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// annotation splits "key = value" or "key: value" comment.
+func annotation(text string) (string, string, bool) {
+	i := strings.IndexAny(text, "=:")
+	if i < 0 {
+		return "", "", false
+	}
+	key, value := strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:])
+	if key == "" || strings.ContainsAny(key, " \t") || value == "" {
+		return "", "", false
+	}
+	return key, value, true
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
-	"github.com/turekt/wgmon/network"
-	"github.com/turekt/wgmon/wg"
+	"github.com/turekt/wgmon/config"
+	"github.com/turekt/wgmon/ctl"
+	"github.com/turekt/wgmon/doctor"
 )
 
 func flagStringEnvOverride(key, value, desc string) *string {
@@ -20,35 +25,278 @@ func flagStringEnvOverride(key, value, desc string) *string {
 	return ptr
 }
 
+func splitList(value string) []string {
+	var list []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 func main() {
-	monitorTypePtr := flagStringEnvOverride("monitor", "nflog", "type of monitor to use (bpf or nflog)")
+	cmd, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "run":
+		run(args)
+	case "doctor":
+		if !runDoctor(args) {
+			os.Exit(1)
+		}
+	case "status", "peers", "history", "sessions", "notify-test":
+		if err := runClient(cmd, args); err != nil {
+			fmt.Fprintln(os.Stderr, "wgmon:", err)
+			os.Exit(1)
+		}
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stderr, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+}
+
+// parseFlags parses daemon flags and returns config file path, if any, and
+// a function loading configuration from the file or from flags.
+func parseFlags(args []string) (string, func() (*config.Config, error)) {
+	configPtr := flagStringEnvOverride("config", "", "yaml configuration file, replaces all other flags and is reloaded on SIGHUP")
+	monitorTypePtr := flagStringEnvOverride("monitor", "nflog", "type of monitor to use (bpf, nflog or poll)")
 	groupPtr := flagStringEnvOverride("group", "1", "nflog group index in case nflog is used as monitor")
 	interfacePtr := flagStringEnvOverride("interface", "eth0", "interface where to listen for packets (if bpf is used)")
 	filterPtr := flagStringEnvOverride("filter", "udp and dst port 3000", "bpf filter triggering wg show (if bpf is used)")
+	pollIntervalPtr := flagStringEnvOverride("poll_interval", "30s", "interval of device snapshots (if poll is used)")
+	pollMaxIntervalPtr := flagStringEnvOverride("poll_max_interval", "5m", "interval of device snapshots backs off up to this while no peer is connected (if poll is used)")
+	devicesPtr := flagStringEnvOverride("devices", "", "comma separated globs of tracked device names (empty tracks all)")
+	excludeDevicesPtr := flagStringEnvOverride("exclude_devices", "", "comma separated globs of device names not tracked")
+	filterPeersPtr := flagStringEnvOverride("filter_peers", "", "comma separated public keys of tracked peers (empty tracks all)")
+	excludePeersPtr := flagStringEnvOverride("exclude_peers", "", "comma separated public keys of peers not tracked")
+	filterTagsPtr := flagStringEnvOverride("filter_tags", "", "comma separated tags, only peers with any of them are tracked")
+	excludeTagsPtr := flagStringEnvOverride("exclude_tags", "", "comma separated tags of peers not tracked")
+	filterAllowedIPsPtr := flagStringEnvOverride("filter_allowed_ips", "", "comma separated cidrs, only peers with allowed ips within any of them are tracked")
+	excludeAllowedIPsPtr := flagStringEnvOverride("exclude_allowed_ips", "", "comma separated cidrs, peers with allowed ips within any of them are not tracked")
 	webhookPtr := flagStringEnvOverride("webhook", "", "custom webhook where to report events")
-	flag.Parse()
+	execPtr := flagStringEnvOverride("exec", "", "command executed via /bin/sh on each event")
+	execTimeoutPtr := flagStringEnvOverride("exec_timeout", "30s", "maximum duration of a single exec command")
+	execConcurrencyPtr := flagStringEnvOverride("exec_concurrency", "4", "maximum number of exec commands running at once")
+	syslogPtr := flagStringEnvOverride("syslog", "", "syslog address (udp://, tcp://, tls:// or unix://) where to report events")
+	journaldPtr := flagStringEnvOverride("journald", "false", "report events to journald")
+	mqttPtr := flagStringEnvOverride("mqtt", "", "mqtt broker url where to publish events and peer presence")
+	smtpPtr := flagStringEnvOverride("smtp", "", "smtp server url used for email notifications")
+	smtpToPtr := flagStringEnvOverride("smtp_to", "", "comma separated email recipients with optional filters")
+	digestPtr := flagStringEnvOverride("digest", "0", "window during which webhook and smtp events are aggregated into a digest (0 disables)")
+	digestMaxPtr := flagStringEnvOverride("digest_max", "50", "maximum number of events in a single digest")
+	digestPriorityPtr := flagStringEnvOverride("digest_priority", "", "comma separated event types or states sent without aggregation")
+	wgConfPtr := flagStringEnvOverride("wg_conf", "/etc/wireguard/*.conf", "pattern of wireguard configs whose peer annotations name peers (empty disables)")
+	inventoryPtr := flagStringEnvOverride("inventory", "", "yaml file with names, owners and tags of peers")
+	geoipPtr := flagStringEnvOverride("geoip", "", "comma separated maxmind city, country or asn .mmdb databases locating endpoints")
+	flapThresholdPtr := flagStringEnvOverride("flap_threshold", "0", "number of state changes within flap_window marking peer as flapping (0 disables)")
+	flapWindowPtr := flagStringEnvOverride("flap_window", "10m", "period in which state changes are counted for flap detection")
+	flapCooldownPtr := flagStringEnvOverride("flap_cooldown", "0", "minimum time between two notifications about the same peer")
+	attemptsIntervalPtr := flagStringEnvOverride("attempts_interval", "10m", "minimum time between reports of failed connection attempts (0 disables)")
+	anomalyLocationPtr := flagStringEnvOverride("anomaly_location", "", "comma separated country, asn or network alerted when never seen in peer history")
+	anomalyConcurrentPtr := flagStringEnvOverride("anomaly_concurrent", "0", "window in which a peer alternating between two addresses is alerted as key used twice (0 disables)")
+	anomalyHoursPtr := flagStringEnvOverride("anomaly_hours", "0", "alert when fewer than this fraction of past sessions started around the same hour (0 disables)")
+	anomalyMinSessionsPtr := flagStringEnvOverride("anomaly_min_sessions", "10", "number of past sessions needed before history based anomalies are alerted")
+	bandwidthPtr := flagStringEnvOverride("bandwidth", "", "comma separated traffic limits, e.g. peer?rate=10MB/s&window=5m,device:wg0?total=1TB&period=month")
+	statePtr := flagStringEnvOverride("state", "", "file where connection state is saved to survive restarts (empty disables)")
+	historyPtr := flagStringEnvOverride("history", "", "session history database file (empty disables)")
+	historyRetentionPtr := flagStringEnvOverride("history_retention", "720h", "how long closed sessions are kept in history")
+	historyMaxPtr := flagStringEnvOverride("history_max", "0", "maximum number of sessions kept in history (0 is unlimited)")
+	metricsPtr := flagStringEnvOverride("metrics", "", "listen address of prometheus metrics endpoint, e.g. :9586")
+	healthPtr := flagStringEnvOverride("health", "", "listen address of /healthz and /readyz endpoints, may equal metrics address")
+	healthPacketTimeoutPtr := flagStringEnvOverride("health_packet_timeout", "15m", "unhealthy when no packets are received for this long while peers handshake")
+	healthMaxPendingPtr := flagStringEnvOverride("health_max_pending", "100", "unhealthy when more notifications are waiting on sinks")
+	apiPtr := flagStringEnvOverride("api", "", "listen address of read-only json api, host:port or unix:///path")
+	apiTokenPtr := flagStringEnvOverride("api_token", "", "bearer token required by api")
+	apiCertPtr := flagStringEnvOverride("api_cert", "", "tls certificate of api server")
+	apiKeyPtr := flagStringEnvOverride("api_key", "", "tls private key of api server")
+	apiClientCAPtr := flagStringEnvOverride("api_client_ca", "", "ca verifying api client certificates (enables mtls)")
+	dashboardPtr := flagStringEnvOverride("dashboard", "false", "serve web dashboard on /ui/ of api address")
+	grpcPtr := flagStringEnvOverride("grpc", "", "listen address of grpc api, host:port or unix:///path")
+	controlPtr := flagStringEnvOverride("control", ctl.DefaultSocket, "control socket used by wgmon client commands (empty disables)")
+	flag.CommandLine.Parse(args)
 
-	var monitor network.Monitor
-	switch *monitorTypePtr {
-	case "bpf":
-		monitor = network.NewBPFMonitor(*interfacePtr, *filterPtr)
-	default:
+	// loadConfig returns configuration from file when provided, otherwise
+	// from flags and environment variables
+	loadConfig := func() (*config.Config, error) {
+		if *configPtr != "" {
+			return config.Load(*configPtr)
+		}
+
+		var errs []error
+		duration := func(name, value string) config.Duration {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid duration %q", name, value))
+			}
+			return config.Duration(d)
+		}
+		integer := func(name, value string) int {
+			i, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid number %q", name, value))
+			}
+			return i
+		}
+		boolean := func(name, value string) bool {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid boolean %q", name, value))
+			}
+			return b
+		}
+		fraction := func(name, value string) float64 {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid number %q", name, value))
+			}
+			return f
+		}
 		group, err := strconv.ParseUint(*groupPtr, 10, 16)
 		if err != nil {
-			slog.Error("unable to parse provided group to uint16", "provided", *groupPtr, "error", err)
+			errs = append(errs, fmt.Errorf("group: invalid nflog group %q", *groupPtr))
+		}
+
+		cfg := config.Default()
+		cfg.Monitor = config.Monitor{
+			Type:        *monitorTypePtr,
+			Group:       uint16(group),
+			Interface:   *interfacePtr,
+			Filter:      *filterPtr,
+			Interval:    duration("poll_interval", *pollIntervalPtr),
+			MaxInterval: duration("poll_max_interval", *pollMaxIntervalPtr),
+		}
+		cfg.Devices = splitList(*devicesPtr)
+		cfg.Filter = config.Filter{
+			ExcludeDevices:    splitList(*excludeDevicesPtr),
+			Peers:             splitList(*filterPeersPtr),
+			ExcludePeers:      splitList(*excludePeersPtr),
+			Tags:              splitList(*filterTagsPtr),
+			ExcludeTags:       splitList(*excludeTagsPtr),
+			AllowedIPs:        splitList(*filterAllowedIPsPtr),
+			ExcludeAllowedIPs: splitList(*excludeAllowedIPsPtr),
+		}
+		cfg.Sinks = config.Sinks{
+			Webhook: *webhookPtr,
+			Exec: config.Exec{
+				Command:     *execPtr,
+				Timeout:     duration("exec_timeout", *execTimeoutPtr),
+				Concurrency: integer("exec_concurrency", *execConcurrencyPtr),
+			},
+			Syslog:   *syslogPtr,
+			Journald: boolean("journald", *journaldPtr),
+			MQTT:     *mqttPtr,
+			SMTP:     config.SMTP{Server: *smtpPtr, To: splitList(*smtpToPtr)},
+			Digest: config.Digest{
+				Window:   duration("digest", *digestPtr),
+				Max:      integer("digest_max", *digestMaxPtr),
+				Priority: splitList(*digestPriorityPtr),
+			},
+		}
+		cfg.Inventory = config.Inventory{WireGuard: *wgConfPtr, File: *inventoryPtr}
+		cfg.GeoIP = splitList(*geoipPtr)
+		cfg.Flap = config.Flap{
+			Threshold: integer("flap_threshold", *flapThresholdPtr),
+			Window:    duration("flap_window", *flapWindowPtr),
+			Cooldown:  duration("flap_cooldown", *flapCooldownPtr),
+		}
+		cfg.Attempts = config.Attempts{Interval: duration("attempts_interval", *attemptsIntervalPtr)}
+		cfg.Anomaly = config.Anomaly{
+			Location:    splitList(*anomalyLocationPtr),
+			Concurrent:  duration("anomaly_concurrent", *anomalyConcurrentPtr),
+			Hours:       fraction("anomaly_hours", *anomalyHoursPtr),
+			MinSessions: integer("anomaly_min_sessions", *anomalyMinSessionsPtr),
+		}
+		cfg.Bandwidth, err = config.ParseBandwidth(*bandwidthPtr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("bandwidth: %w", err))
+		}
+		cfg.Health = config.Health{
+			Listen:        *healthPtr,
+			PacketTimeout: duration("health_packet_timeout", *healthPacketTimeoutPtr),
+			MaxPending:    integer("health_max_pending", *healthMaxPendingPtr),
+		}
+		cfg.State = *statePtr
+		cfg.History = config.History{
+			Path:      *historyPtr,
+			Retention: duration("history_retention", *historyRetentionPtr),
+			Max:       integer("history_max", *historyMaxPtr),
 		}
-		monitor = network.NewNFLogMonitor(uint16(group), 0)
+		cfg.Metrics = *metricsPtr
+		cfg.API = config.API{
+			Listen:    *apiPtr,
+			Token:     *apiTokenPtr,
+			Cert:      *apiCertPtr,
+			Key:       *apiKeyPtr,
+			ClientCA:  *apiClientCAPtr,
+			Dashboard: boolean("dashboard", *dashboardPtr),
+		}
+		cfg.GRPC = *grpcPtr
+		cfg.Control = *controlPtr
+		if len(errs) > 0 {
+			return nil, errors.Join(errs...)
+		}
+		return cfg, cfg.Validate()
+	}
+
+	if *configPtr != "" {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name != "config" {
+				slog.Warn("flag ignored in favour of config file", "flag", f.Name, "config", *configPtr)
+			}
+		})
 	}
+	return *configPtr, loadConfig
+}
 
-	tracker, err := wg.NewTracker(monitor, *webhookPtr)
+// runDoctor checks setup described by daemon flags or config file and
+// reports whether all checks passed.
+func runDoctor(args []string) bool {
+	_, loadConfig := parseFlags(args)
+	cfg, err := loadConfig()
 	if err != nil {
-		slog.Error("failed to initiate tracker", "error", err)
-		return
+		fmt.Fprintln(os.Stderr, "invalid configuration:", err)
+		return false
 	}
-	tracker.Start()
+	return doctor.Print(os.Stdout, doctor.Run(cfg))
+}
+
+func run(args []string) {
+	configPath, loadConfig := parseFlags(args)
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(2)
+	}
+
+	d, err := newDaemon(cfg)
+	if err != nil {
+		slog.Error("failed to start", "error", err)
+		os.Exit(1)
+	}
+	go d.tracker.Start()
 
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-	<-sigc
-	tracker.Stop()
+	for sig := range sigc {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		if configPath == "" {
+			slog.Warn("ignoring SIGHUP, no config file to reload")
+			continue
+		}
+		cfg, err := loadConfig()
+		if err != nil {
+			slog.Error("config reload failed, keeping current config", "error", err)
+			continue
+		}
+		d.reload(cfg)
+	}
+	d.stop()
 }
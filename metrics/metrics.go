@@ -0,0 +1,249 @@
+// Package metrics implements a minimal subset of Prometheus client
+// functionality, enough to expose counters, gauges and histograms in text
+// exposition format without pulling in the whole client library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type metricType int
+
+const (
+	typeCounter metricType = iota
+	typeGauge
+	typeHistogram
+)
+
+func (mt metricType) String() string {
+	switch mt {
+	case typeCounter:
+		return "counter"
+	case typeGauge:
+		return "gauge"
+	default:
+		return "histogram"
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+type Registry struct {
+	mu       sync.Mutex
+	scrapeMu sync.Mutex
+	families []*Vec
+	scrapes  []func()
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Default registry used by wgmon components.
+var Default = NewRegistry()
+
+func (r *Registry) Counter(name, help string, labels ...string) *Vec {
+	return r.register(&Vec{name: name, help: help, typ: typeCounter, labels: labels})
+}
+
+func (r *Registry) Gauge(name, help string, labels ...string) *Vec {
+	return r.register(&Vec{name: name, help: help, typ: typeGauge, labels: labels})
+}
+
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Vec {
+	return r.register(&Vec{name: name, help: help, typ: typeHistogram, labels: labels, buckets: buckets})
+}
+
+// OnScrape registers function called before every scrape, used to refresh
+// gauges mirroring external state.
+func (r *Registry) OnScrape(f func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scrapes = append(r.scrapes, f)
+}
+
+func (r *Registry) register(v *Vec) *Vec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range r.families {
+		if f.name == v.name {
+			return f
+		}
+	}
+	v.values = make(map[string]*Value)
+	r.families = append(r.families, v)
+	return v
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// Write renders all metrics in Prometheus text exposition format.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	scrapes := slices.Clone(r.scrapes)
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+
+	// scrape functions reset and refill families, serialize them with output
+	r.scrapeMu.Lock()
+	defer r.scrapeMu.Unlock()
+	for _, f := range scrapes {
+		f()
+	}
+
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	for _, v := range families {
+		v.write(bw)
+	}
+}
+
+// Vec is a metric family partitioned by label values.
+type Vec struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	typ     metricType
+	labels  []string
+	buckets []float64
+	values  map[string]*Value
+}
+
+// With returns metric for label values given in order of label names.
+func (v *Vec) With(values ...string) *Value {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s expects %d labels, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if m, ok := v.values[key]; ok {
+		return m
+	}
+	m := &Value{labels: slices.Clone(values), buckets: v.buckets}
+	if v.typ == typeHistogram {
+		m.counts = make([]uint64, len(v.buckets))
+	}
+	v.values[key] = m
+	return m
+}
+
+// Reset removes all label combinations.
+func (v *Vec) Reset() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	clear(v.values)
+}
+
+func (v *Vec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.values) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.typ)
+	keys := make([]string, 0, len(v.values))
+	for k := range v.values {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		m := v.values[k]
+		m.mu.Lock()
+		if v.typ != typeHistogram {
+			fmt.Fprintf(w, "%s%s %s\n", v.name, v.labelString(m.labels, "", ""), formatFloat(m.value))
+			m.mu.Unlock()
+			continue
+		}
+		for i, b := range v.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, v.labelString(m.labels, "le", formatFloat(b)), m.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, v.labelString(m.labels, "le", "+Inf"), m.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, v.labelString(m.labels, "", ""), formatFloat(m.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, v.labelString(m.labels, "", ""), m.count)
+		m.mu.Unlock()
+	}
+}
+
+func (v *Vec) labelString(values []string, extraName, extraValue string) string {
+	pairs := make([]string, 0, len(values)+1)
+	for i, name := range v.labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Value holds a single sample; counters use Inc/Add, gauges Set and
+// histograms Observe.
+type Value struct {
+	mu      sync.Mutex
+	labels  []string
+	buckets []float64
+	value   float64
+	counts  []uint64
+	count   uint64
+}
+
+func (m *Value) Inc() {
+	m.Add(1)
+}
+
+func (m *Value) Add(delta float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.value += delta
+}
+
+func (m *Value) Set(value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.value = value
+}
+
+// Observe records histogram observation, buckets are cumulative.
+func (m *Value) Observe(value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, b := range m.buckets {
+		if value <= b {
+			m.counts[i]++
+		}
+	}
+	m.count++
+	m.value += value
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
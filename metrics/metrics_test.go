@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	counter := r.Counter("test_events_total", "Number of events.", "sink", "result")
+	gauge := r.Gauge("test_connected", "Connected peers.")
+	histogram := r.Histogram("test_duration_seconds", "Duration.", []float64{0.1, 1})
+	r.Counter("test_unused_total", "Never set.")
+
+	counter.With("webhook", "success").Inc()
+	counter.With("webhook", "success").Add(2)
+	counter.With(`a"b`, "failure").Inc()
+	r.OnScrape(func() {
+		gauge.Reset()
+		gauge.With().Set(5)
+	})
+	histogram.With().Observe(0.05)
+	histogram.With().Observe(0.5)
+
+	var b strings.Builder
+	r.Write(&b)
+	out := b.String()
+
+	for _, want := range []string{
+		"# TYPE test_events_total counter\n",
+		`test_events_total{sink="webhook",result="success"} 3` + "\n",
+		`test_events_total{sink="a\"b",result="failure"} 1` + "\n",
+		"# TYPE test_connected gauge\ntest_connected 5\n",
+		`test_duration_seconds_bucket{le="0.1"} 1` + "\n",
+		`test_duration_seconds_bucket{le="1"} 2` + "\n",
+		`test_duration_seconds_bucket{le="+Inf"} 2` + "\n",
+		"test_duration_seconds_sum 0.55\n",
+		"test_duration_seconds_count 2\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in output:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "test_unused_total") {
+		t.Errorf("unexpected empty family in output:\n%s", out)
+	}
+}
@@ -2,6 +2,7 @@ package network
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
@@ -10,6 +11,7 @@ import (
 )
 
 type Monitor interface {
+	Name() string
 	Open() error
 	Watch()
 	Close()
@@ -23,6 +25,7 @@ type NFLogMonitor struct {
 	ns    int
 	C     chan gopacket.Packet
 	S     chan byte
+	once  sync.Once
 }
 
 func NewNFLogMonitor(group uint16, ns int) Monitor {
@@ -34,6 +37,10 @@ func NewNFLogMonitor(group uint16, ns int) Monitor {
 	}
 }
 
+func (n *NFLogMonitor) Name() string {
+	return "nflog"
+}
+
 func (n *NFLogMonitor) ShutdownChan() chan byte {
 	return n.S
 }
@@ -51,12 +58,14 @@ func (n *NFLogMonitor) Open() error {
 	return nil
 }
 
+// Watch forwards logged packets to packet channel until monitor is
+// closed, the packet channel is closed on return.
 func (n *NFLogMonitor) Watch() {
+	defer close(n.C)
 	for {
 		select {
 		case <-n.S:
-			n.Close()
-			break
+			return
 		default:
 			msgs, err := n.conn.Receive()
 			if err != nil {
@@ -82,20 +91,28 @@ func (n *NFLogMonitor) Watch() {
 						break
 					}
 				}
-				if p != nil {
-					n.C <- p
+				if p == nil {
+					continue
+				}
+				select {
+				case n.C <- p:
+				case <-n.S:
+					return
 				}
 			}
 		}
 	}
 }
 
+// Close stops watching and releases netlink socket, it is safe to call
+// multiple times.
 func (n *NFLogMonitor) Close() {
-	close(n.C)
-	close(n.S)
-	if n.conn != nil {
-		n.conn.Close()
-	}
+	n.once.Do(func() {
+		close(n.S)
+		if n.conn != nil {
+			n.conn.Close()
+		}
+	})
 }
 
 type BPFMonitor struct {
@@ -104,6 +121,7 @@ type BPFMonitor struct {
 	C      chan gopacket.Packet
 	S      chan byte
 	handle *pcap.Handle
+	once   sync.Once
 }
 
 func NewBPFMonitor(intf, filter string) Monitor {
@@ -115,6 +133,10 @@ func NewBPFMonitor(intf, filter string) Monitor {
 	}
 }
 
+func (m *BPFMonitor) Name() string {
+	return "bpf"
+}
+
 func (m *BPFMonitor) ShutdownChan() chan byte {
 	return m.S
 }
@@ -153,23 +175,36 @@ func (m *BPFMonitor) Open() error {
 	return nil
 }
 
+// Watch forwards captured packets to packet channel until monitor is
+// closed, the packet channel is closed on return.
 func (m *BPFMonitor) Watch() {
+	defer close(m.C)
 	packetSource := gopacket.NewPacketSource(m.handle, m.handle.LinkType())
+	packets := packetSource.Packets()
 	for {
 		select {
-		case packet := <-packetSource.Packets():
-			m.C <- packet
+		case packet, ok := <-packets:
+			if !ok {
+				return
+			}
+			select {
+			case m.C <- packet:
+			case <-m.S:
+				return
+			}
 		case <-m.S:
-			m.Close()
-			break
+			return
 		}
 	}
 }
 
+// Close stops watching and releases pcap handle, it is safe to call
+// multiple times.
 func (m *BPFMonitor) Close() {
-	close(m.C)
-	close(m.S)
-	if m.handle != nil {
-		m.handle.Close()
-	}
+	m.once.Do(func() {
+		close(m.S)
+		if m.handle != nil {
+			m.handle.Close()
+		}
+	})
 }
@@ -0,0 +1,118 @@
+package network
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+var (
+	PollDefaultInterval    = 30 * time.Second
+	PollDefaultMaxInterval = 5 * time.Minute
+)
+
+// Poller is a monitor without packet capture. Instead of packets it sends
+// times when devices should be snapshot, after handling each of them the
+// receiver reports with Polled whether any peer is connected.
+type Poller interface {
+	Monitor
+	PollChan() chan time.Time
+	Polled(idle bool)
+}
+
+// PollMonitor asks for device snapshots every interval, for hosts where
+// neither nflog nor pcap is available. While no peer is connected the
+// interval doubles up to maxInterval and it is reset once a peer connects.
+type PollMonitor struct {
+	interval    time.Duration
+	maxInterval time.Duration
+	C           chan gopacket.Packet
+	P           chan time.Time
+	S           chan byte
+	idle        chan bool
+	once        sync.Once
+}
+
+func NewPollMonitor(interval, maxInterval time.Duration) Monitor {
+	return &PollMonitor{
+		interval:    interval,
+		maxInterval: max(interval, maxInterval),
+		C:           make(chan gopacket.Packet),
+		P:           make(chan time.Time),
+		S:           make(chan byte, 1),
+		idle:        make(chan bool),
+	}
+}
+
+func (m *PollMonitor) Name() string {
+	return "poll"
+}
+
+func (m *PollMonitor) ShutdownChan() chan byte {
+	return m.S
+}
+
+// PacketChan never delivers packets, it is closed with poll channel.
+func (m *PollMonitor) PacketChan() chan gopacket.Packet {
+	return m.C
+}
+
+func (m *PollMonitor) PollChan() chan time.Time {
+	return m.P
+}
+
+func (m *PollMonitor) Open() error {
+	return nil
+}
+
+// Watch sends the first poll immediately and following ones after the
+// current interval, each once the previous poll was handled. Poll and
+// packet channels are closed on return.
+func (m *PollMonitor) Watch() {
+	defer close(m.C)
+	defer close(m.P)
+	interval := m.interval
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case now := <-timer.C:
+			select {
+			case m.P <- now:
+			case <-m.S:
+				return
+			}
+			select {
+			case idle := <-m.idle:
+				if !idle {
+					interval = m.interval
+				}
+				timer.Reset(interval)
+				if idle {
+					interval = min(2*interval, m.maxInterval)
+				}
+			case <-m.S:
+				return
+			}
+		case <-m.S:
+			return
+		}
+	}
+}
+
+// Polled reports outcome of the last poll, it returns immediately when
+// monitor is closed.
+func (m *PollMonitor) Polled(idle bool) {
+	select {
+	case m.idle <- idle:
+	case <-m.S:
+	}
+}
+
+// Close stops watching, it is safe to call multiple times.
+func (m *PollMonitor) Close() {
+	m.once.Do(func() {
+		close(m.S)
+	})
+}
@@ -0,0 +1,43 @@
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPollMonitor(t *testing.T) {
+	m := NewPollMonitor(10*time.Millisecond, 80*time.Millisecond).(*PollMonitor)
+	if err := m.Open(); err != nil {
+		t.Fatal(err)
+	}
+	go m.Watch()
+
+	// first poll is immediate, idle polls back off up to the maximum
+	// and a connected peer resets the interval
+	idle := []bool{true, true, true, true, true, false, true}
+	want := []time.Duration{0, 10, 20, 40, 80, 80, 10}
+	last := time.Now()
+	for i := range idle {
+		now, ok := <-m.PollChan()
+		if !ok {
+			t.Fatal("poll channel closed")
+		}
+		got := now.Sub(last)
+		if w := want[i] * time.Millisecond; got < w || got > w+40*time.Millisecond {
+			t.Errorf("case #%d, unexpected interval: got %v, want %v", i, got, w)
+		}
+		last = now
+		m.Polled(idle[i])
+	}
+
+	m.Close()
+	m.Close()
+	if _, ok := <-m.PollChan(); ok {
+		t.Error("poll channel should be closed")
+	}
+	if _, ok := <-m.PacketChan(); ok {
+		t.Error("packet channel should be closed")
+	}
+	// polled after close does not block
+	m.Polled(true)
+}
@@ -0,0 +1,26 @@
+package network
+
+import (
+	"encoding/binary"
+
+	"github.com/google/gopacket"
+)
+
+const (
+	// wireguard handshake initiation message type and size
+	messageInitiationType = 1
+	messageInitiationSize = 148
+)
+
+// IsHandshakeInitiation reports whether packet carries a wireguard
+// handshake initiation, which clients retransmit every 5 seconds until
+// they get a response.
+func IsHandshakeInitiation(packet gopacket.Packet) bool {
+	transport := packet.TransportLayer()
+	if transport == nil {
+		return false
+	}
+	payload := transport.LayerPayload()
+	return len(payload) == messageInitiationSize &&
+		binary.LittleEndian.Uint32(payload) == messageInitiationType
+}
@@ -0,0 +1,193 @@
+package wg
+
+import (
+	"cmp"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/turekt/wgmon/hook"
+	"github.com/turekt/wgmon/metrics"
+	"github.com/turekt/wgmon/network"
+)
+
+// Classes of failed connection attempts.
+const (
+	// AttemptScanner sends anything but retried handshakes
+	AttemptScanner = "scanner"
+	// AttemptWrongKey retries handshakes no peer responds to
+	AttemptWrongKey = "wrong_key"
+	// AttemptStaleKey retries handshakes from an address used by a known
+	// peer, most likely a client left with an old key
+	AttemptStaleKey = "stale_key"
+)
+
+var AttemptsDefaultInterval = 10 * time.Minute
+
+const (
+	// attemptSettle gives sources time to complete or retry a handshake
+	// before they are classified
+	attemptSettle = 30 * time.Second
+	// maxAttemptSources bounds memory used by probing from many addresses,
+	// further sources are ignored until the next report
+	maxAttemptSources = 1000
+	maxAttemptAddrs   = 16
+)
+
+var failedAttempts = metrics.Default.Counter(
+	"wgmon_failed_attempts_total", "Number of packets from sources which never completed a handshake.", "class")
+
+// AttemptDetector collects packets from sources not matching the endpoint
+// of any peer and reports them as failed connection attempts, at most once
+// per interval.
+type AttemptDetector struct {
+	mu       sync.Mutex
+	interval time.Duration
+	sources  map[string]*attemptSource
+	reported time.Time
+}
+
+// attemptSource holds packets of a single IP address.
+type attemptSource struct {
+	first, last time.Time
+	count       int
+	handshakes  int
+	addrs       []string
+}
+
+func NewAttemptDetector(interval time.Duration) *AttemptDetector {
+	return &AttemptDetector{interval: interval, sources: make(map[string]*attemptSource)}
+}
+
+// SetInterval changes minimal time between two reports, zero disables
+// detection and drops pending sources.
+func (a *AttemptDetector) SetInterval(interval time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.interval = interval
+	if interval <= 0 {
+		clear(a.sources)
+	}
+}
+
+// Add records packet from a source which did not match any peer.
+func (a *AttemptDetector) Add(p *network.PacketDetails, handshake bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.interval <= 0 {
+		return
+	}
+	s, ok := a.sources[p.SrcIP]
+	if !ok {
+		if len(a.sources) >= maxAttemptSources {
+			return
+		}
+		s = &attemptSource{first: p.Time}
+		a.sources[p.SrcIP] = s
+	}
+	s.last = p.Time
+	s.count++
+	if handshake {
+		s.handshakes++
+	}
+	addr := p.RemoteAddr()
+	if i := slices.Index(s.addrs, addr); i >= 0 {
+		s.addrs = slices.Delete(s.addrs, i, i+1)
+	}
+	s.addrs = append(s.addrs, addr)
+	if len(s.addrs) > maxAttemptAddrs {
+		s.addrs = s.addrs[1:]
+	}
+}
+
+// Pending reports whether there are sources waiting to be reported.
+func (a *AttemptDetector) Pending() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.sources) > 0
+}
+
+// Report returns event summarizing failed attempts once interval passed
+// since the previous report, nil when there is nothing to report. Sources
+// whose address became endpoint of a peer in the meantime succeeded and
+// are dropped. Known returns peer id whose last endpoint used ip.
+func (a *AttemptDetector) Report(now time.Time, connected func(addr string) bool, known func(ip string) string) *hook.Event {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for ip, s := range a.sources {
+		if slices.ContainsFunc(s.addrs, connected) {
+			delete(a.sources, ip)
+		}
+	}
+	if len(a.sources) == 0 || now.Sub(a.reported) < a.interval {
+		return nil
+	}
+
+	var attempts []hook.Attempt
+	for ip, s := range a.sources {
+		if now.Sub(s.first) < attemptSettle {
+			continue
+		}
+		attempt := hook.Attempt{
+			Source: s.addrs[len(s.addrs)-1],
+			Class:  AttemptScanner,
+			Count:  s.count,
+			First:  s.first,
+			Last:   s.last,
+		}
+		if s.handshakes > 1 {
+			attempt.Class = AttemptWrongKey
+			if id := known(ip); id != "" {
+				attempt.Class = AttemptStaleKey
+				attempt.Device, attempt.Peer, _ = strings.Cut(id, ":")
+			}
+		}
+		failedAttempts.With(attempt.Class).Add(float64(s.count))
+		attempts = append(attempts, attempt)
+		delete(a.sources, ip)
+	}
+	if len(attempts) == 0 {
+		return nil
+	}
+	slices.SortFunc(attempts, func(x, y hook.Attempt) int {
+		return cmp.Or(y.Count-x.Count, strings.Compare(x.Source, y.Source))
+	})
+	a.reported = now
+	return hook.NewAttemptsEvent(attempts)
+}
+
+// knownPeer returns device:key of the peer whose last endpoint used ip.
+func (t *Tracker) knownPeer(ip string) string {
+	for _, id := range sortedKeys(t.endpoints) {
+		if host, _, err := net.SplitHostPort(t.endpoints[id]); err == nil && host == ip {
+			return id
+		}
+	}
+	return ""
+}
+
+// SetAttemptsInterval configures how often failed connection attempts are
+// reported, zero disables reporting.
+func (t *Tracker) SetAttemptsInterval(interval time.Duration) {
+	t.attempts.SetInterval(interval)
+}
+
+func (t *Tracker) reportAttempts(now time.Time) {
+	connected := func(addr string) bool {
+		_, ok := t.connMap[addr]
+		return ok
+	}
+	if e := t.attempts.Report(now, connected, t.knownPeer); e != nil {
+		for i := range e.Attempts {
+			a := &e.Attempts[i]
+			if info, ok := t.PeerInfo(a.Device, a.Peer); ok && a.Peer != "" {
+				a.Name = info.Name
+			}
+			a.Location = t.locate(a.Source)
+		}
+		t.notify(e)
+	}
+}
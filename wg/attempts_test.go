@@ -0,0 +1,88 @@
+package wg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/turekt/wgmon/hook"
+	"github.com/turekt/wgmon/network"
+)
+
+func TestAttemptDetector(t *testing.T) {
+	start := time.Now()
+	packet := func(addr string, seconds int) *network.PacketDetails {
+		return &network.PacketDetails{SrcIP: addr, SrcPort: "51820", Time: start.Add(time.Duration(seconds) * time.Second)}
+	}
+	connected := func(addr string) bool {
+		return addr == "10.0.0.4:51820"
+	}
+	known := func(ip string) string {
+		if ip == "10.0.0.3" {
+			return "wg0:key"
+		}
+		return ""
+	}
+
+	a := NewAttemptDetector(10 * time.Minute)
+	a.Add(packet("10.0.0.1", 0), false)
+	a.Add(packet("10.0.0.1", 1), true)
+	for i := 0; i < 3; i++ {
+		a.Add(packet("10.0.0.2", i*5), true)
+		a.Add(packet("10.0.0.3", i*5), true)
+	}
+	// handshake completed after the packet was seen
+	a.Add(packet("10.0.0.4", 0), true)
+	a.Add(packet("10.0.0.5", 50), true)
+
+	e := a.Report(start.Add(time.Minute), connected, known)
+	if e == nil {
+		t.Fatal("expected attempts event")
+	}
+	if got, want := e.Type, hook.EventAttempts; got != want {
+		t.Errorf("unexpected type: got %v, want %v", got, want)
+	}
+	if got, want := e.Count, 8; got != want {
+		t.Errorf("unexpected count: got %d, want %d", got, want)
+	}
+	classes := make(map[string]hook.Attempt)
+	for _, at := range e.Attempts {
+		classes[at.Source] = at
+	}
+	testCases := []struct {
+		source string
+		class  string
+	}{
+		{"10.0.0.1:51820", AttemptScanner},
+		{"10.0.0.2:51820", AttemptWrongKey},
+		{"10.0.0.3:51820", AttemptStaleKey},
+		{"10.0.0.4:51820", ""},
+		// too recent to be classified
+		{"10.0.0.5:51820", ""},
+	}
+	for i, tc := range testCases {
+		if got, want := classes[tc.source].Class, tc.class; got != want {
+			t.Errorf("case #%d %s, unexpected class: got %q, want %q", i, tc.source, got, want)
+		}
+	}
+	if got, want := classes["10.0.0.3:51820"].Peer, "key"; got != want {
+		t.Errorf("unexpected stale peer: got %v, want %v", got, want)
+	}
+
+	// rate limited until interval passes
+	if !a.Pending() {
+		t.Error("recent source should stay pending")
+	}
+	if e := a.Report(start.Add(5*time.Minute), connected, known); e != nil {
+		t.Errorf("unexpected report within interval: %v", e.Attempts)
+	}
+	e = a.Report(start.Add(11*time.Minute), connected, known)
+	if e == nil || len(e.Attempts) != 1 || e.Attempts[0].Source != "10.0.0.5:51820" {
+		t.Errorf("unexpected report after interval: %v", e)
+	}
+
+	a.SetInterval(0)
+	a.Add(packet("10.0.0.1", 0), true)
+	if a.Pending() {
+		t.Error("disabled detector should not collect sources")
+	}
+}
@@ -2,9 +2,10 @@ package wg
 
 import (
 	"fmt"
-	"sync"
+	"slices"
 	"time"
 
+	"github.com/turekt/wgmon/hook"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
@@ -38,23 +39,37 @@ func (cs ConnectionState) String() (str string) {
 
 var idleTimeout = 5 * time.Minute
 
+// Connection tracks a peer between snapshots, it is owned by the tracker
+// event loop and not safe for concurrent use.
 type Connection struct {
-	mu     sync.RWMutex
 	device string
 	prev   *wgtypes.Peer
 	curr   *wgtypes.Peer
 	opened bool
+	since  time.Time
 }
 
 func (c *Connection) State() ConnectionState {
+	return c.state(true)
+}
+
+// Peek computes connection state same as State but without registering
+// connection as opened or closed.
+func (c *Connection) Peek() ConnectionState {
+	return c.state(false)
+}
+
+func (c *Connection) state(update bool) ConnectionState {
 	connRunBasedOnFlag := func() ConnectionState {
-		if c.opened {
+		if c.Opened() {
 			// conn already registered, nothing new
 			return ConnectionEstablished
 		}
 
 		// newly opened conn
-		c.setOpened(true)
+		if update {
+			c.setOpened(true)
+		}
 		return ConnectionOpened
 	}
 
@@ -79,7 +94,9 @@ func (c *Connection) State() ConnectionState {
 	if c.curr.LastHandshakeTime.Before(time.Now().Add(-1 * idleTimeout)) {
 		// conn idle for too long, disconnected
 		if c.Opened() {
-			c.setOpened(false)
+			if update {
+				c.setOpened(false)
+			}
 			return ConnectionClosed
 		}
 
@@ -95,14 +112,19 @@ func (c *Connection) IsTransferring() bool {
 }
 
 func (c *Connection) setOpened(state bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
 	c.opened = state
+	c.since = time.Time{}
+	if state {
+		c.since = time.Now()
+	}
+}
+
+// Since returns time when connection was registered as opened.
+func (c *Connection) Since() time.Time {
+	return c.since
 }
 
 func (c *Connection) Opened() bool {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
 	return c.opened
 }
 
@@ -110,26 +132,48 @@ func (c *Connection) ID() string {
 	return fmt.Sprintf("%s:%s", c.device, c.curr.PublicKey.String())
 }
 
-type ConnectionMap struct {
-	sync.Map
+func (c *Connection) Event(endpoint string, state ConnectionState) *hook.Event {
+	e := hook.NewStateEvent(c.device, c.curr.PublicKey.String(), endpoint, state.String())
+	e.ReceiveBytes = c.curr.ReceiveBytes
+	e.TransmitBytes = c.curr.TransmitBytes
+	return e
 }
 
-func NewConnectionMap() *ConnectionMap {
-	return &ConnectionMap{}
+// ConnectionMap holds connections by peer endpoint, it is owned by the
+// tracker event loop and not safe for concurrent use.
+type ConnectionMap map[string]*Connection
+
+func NewConnectionMap() ConnectionMap {
+	return make(ConnectionMap)
 }
 
-func (t *ConnectionMap) Snapshot(devices []*wgtypes.Device) {
+func (t ConnectionMap) Snapshot(devices []*wgtypes.Device) {
 	for _, dev := range devices {
 		for _, peer := range dev.Peers {
 			key := peer.Endpoint.String()
-			conn := &Connection{device: dev.Name}
-			if v, ok := t.Load(key); ok {
-				conn = v.(*Connection)
+			conn, ok := t[key]
+			if !ok {
+				conn = &Connection{device: dev.Name}
+				t[key] = conn
 			}
 
 			conn.prev = conn.curr
 			conn.curr = &peer
-			t.Store(key, conn)
 		}
 	}
 }
+
+// Endpoints returns endpoints of connections in sorted order, so that
+// connections are visited and their events emitted deterministically.
+func (t ConnectionMap) Endpoints() []string {
+	return sortedKeys(t)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
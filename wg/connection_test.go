@@ -54,24 +54,19 @@ func TestSnapshotState(t *testing.T) {
 	connMap := NewConnectionMap()
 	connMap.Snapshot(devices)
 
-	connMap.Range(func(k, v any) bool {
-		conn := v.(*Connection)
+	for _, conn := range connMap {
 		if got, want := conn.State(), ConnectionUndefined; got != want {
 			t.Fatalf("unexpected first conn state: got %v, want %v", got, want)
-			return false
 		}
-		return true
-	})
+	}
 
 	devices[0].Peers[0].TransmitBytes = 300
 	connMap.Snapshot(devices)
 
-	conn, _ := connMap.Load(Client1)
-	if got, want := conn.(*Connection).State(), ConnectionOpened; got != want {
+	if got, want := connMap[Client1].State(), ConnectionOpened; got != want {
 		t.Fatalf("unexpected second conn state %s: got %v, want %v", Client1, got, want)
 	}
-	conn, _ = connMap.Load(Client2)
-	if got, want := conn.(*Connection).State(), ConnectionInactive; got != want {
+	if got, want := connMap[Client2].State(), ConnectionInactive; got != want {
 		t.Fatalf("unexpected second conn state %s: got %v, want %v", Client1, got, want)
 	}
 }
@@ -224,3 +219,30 @@ func TestState(t *testing.T) {
 		}
 	}
 }
+
+func TestPeek(t *testing.T) {
+	tMinus1 := time.Now().Add(-1 * time.Minute)
+	conn := &Connection{
+		prev: &wgtypes.Peer{},
+		curr: &wgtypes.Peer{LastHandshakeTime: tMinus1},
+	}
+
+	for range 2 {
+		if got, want := conn.Peek(), ConnectionOpened; got != want {
+			t.Fatalf("unexpected peek state: got %v, want %v", got, want)
+		}
+	}
+	if conn.Opened() || !conn.Since().IsZero() {
+		t.Fatalf("peek registered connection as opened")
+	}
+
+	if got, want := conn.State(), ConnectionOpened; got != want {
+		t.Fatalf("unexpected state: got %v, want %v", got, want)
+	}
+	if got, want := conn.Peek(), ConnectionEstablished; got != want {
+		t.Errorf("unexpected peek state after open: got %v, want %v", got, want)
+	}
+	if conn.Since().IsZero() {
+		t.Errorf("missing session start after open")
+	}
+}
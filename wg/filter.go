@@ -0,0 +1,139 @@
+package wg
+
+import (
+	"net"
+	"net/netip"
+	"path"
+	"slices"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Filter selects tracked devices and peers. Empty include lists select
+// everything and excludes take precedence over includes. Peers of
+// excluded devices and excluded peers are left out of snapshots, so they
+// produce no events, metrics or status.
+type Filter struct {
+	// device name globs
+	Devices        []string
+	ExcludeDevices []string
+	// peer public keys
+	Peers        []string
+	ExcludePeers []string
+	// peer tags from inventory
+	Tags        []string
+	ExcludeTags []string
+	// prefixes containing any of peer allowed ips
+	AllowedIPs        []netip.Prefix
+	ExcludeAllowedIPs []netip.Prefix
+}
+
+// Device reports whether device name is selected.
+func (f *Filter) Device(name string) bool {
+	if f == nil {
+		return true
+	}
+	match := func(pattern string) bool {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}
+	if slices.ContainsFunc(f.ExcludeDevices, match) {
+		return false
+	}
+	return len(f.Devices) == 0 || slices.ContainsFunc(f.Devices, match)
+}
+
+// Peer reports whether peer with tags is selected.
+func (f *Filter) Peer(peer *wgtypes.Peer, tags []string) bool {
+	if f == nil {
+		return true
+	}
+	key := peer.PublicKey.String()
+	hasTag := func(tag string) bool {
+		return slices.Contains(tags, tag)
+	}
+	contains := func(prefix netip.Prefix) bool {
+		return slices.ContainsFunc(peer.AllowedIPs, func(ipn net.IPNet) bool {
+			addr, ok := netip.AddrFromSlice(ipn.IP)
+			bits, _ := ipn.Mask.Size()
+			return ok && bits >= prefix.Bits() && prefix.Contains(addr.Unmap())
+		})
+	}
+	switch {
+	case slices.Contains(f.ExcludePeers, key),
+		slices.ContainsFunc(f.ExcludeTags, hasTag),
+		slices.ContainsFunc(f.ExcludeAllowedIPs, contains):
+		return false
+	}
+	return (len(f.Peers) == 0 || slices.Contains(f.Peers, key)) &&
+		(len(f.Tags) == 0 || slices.ContainsFunc(f.Tags, hasTag)) &&
+		(len(f.AllowedIPs) == 0 || slices.ContainsFunc(f.AllowedIPs, contains))
+}
+
+// SetFilter limits tracking to devices and peers selected by f, nil tracks
+// everything. Tracked connections no longer selected are dropped without
+// notification.
+func (t *Tracker) SetFilter(f *Filter) {
+	t.filter.Store(f)
+	devices, err := t.devices()
+	if err != nil {
+		// dropped with the next successful snapshot
+		return
+	}
+	selected := make(map[string]bool)
+	for _, dev := range devices {
+		for _, peer := range dev.Peers {
+			selected[dev.Name+":"+peer.PublicKey.String()] = true
+		}
+	}
+	t.do(func() {
+		for endpoint, conn := range t.connMap {
+			if conn.curr != nil && !selected[conn.ID()] {
+				delete(t.connMap, endpoint)
+			}
+		}
+	})
+}
+
+// filterDevices removes devices and peers not selected by filter, returned
+// set holds ids and endpoints of removed peers.
+func (t *Tracker) filterDevices(devices []*wgtypes.Device) ([]*wgtypes.Device, map[string]bool) {
+	f := t.filter.Load()
+	if f == nil {
+		return devices, nil
+	}
+	excluded := make(map[string]bool)
+	exclude := func(dev string, peer *wgtypes.Peer) {
+		excluded[dev+":"+peer.PublicKey.String()] = true
+		if peer.Endpoint != nil {
+			excluded[peer.Endpoint.String()] = true
+		}
+	}
+
+	selected := make([]*wgtypes.Device, 0, len(devices))
+	for _, dev := range devices {
+		if !f.Device(dev.Name) {
+			for i := range dev.Peers {
+				exclude(dev.Name, &dev.Peers[i])
+			}
+			continue
+		}
+		d := *dev
+		d.Peers = slices.DeleteFunc(slices.Clone(dev.Peers), func(p wgtypes.Peer) bool {
+			info, _ := t.PeerInfo(dev.Name, p.PublicKey.String())
+			if !f.Peer(&p, info.Tags) {
+				exclude(dev.Name, &p)
+				return true
+			}
+			return false
+		})
+		selected = append(selected, &d)
+	}
+	return selected, excluded
+}
+
+// isExcluded reports whether endpoint or device:key belonged to a peer left
+// out by filter in the last snapshot.
+func (t *Tracker) isExcluded(key string) bool {
+	return t.excluded[key]
+}
@@ -0,0 +1,112 @@
+package wg
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestFilterDevice(t *testing.T) {
+	testCases := []struct {
+		filter *Filter
+		name   string
+		expect bool
+	}{
+		{nil, "wg0", true},
+		{&Filter{}, "wg0", true},
+		{&Filter{Devices: []string{"wg-cust*"}}, "wg-customers", true},
+		{&Filter{Devices: []string{"wg-cust*"}}, "wg-mgmt", false},
+		{&Filter{ExcludeDevices: []string{"wg-mgmt"}}, "wg-mgmt", false},
+		{&Filter{ExcludeDevices: []string{"wg-mgmt"}}, "wg0", true},
+		{&Filter{Devices: []string{"wg*"}, ExcludeDevices: []string{"wg-mgmt"}}, "wg-mgmt", false},
+	}
+	for i, tc := range testCases {
+		if got := tc.filter.Device(tc.name); got != tc.expect {
+			t.Errorf("case #%d %s, unexpected result: got %v, want %v", i, tc.name, got, tc.expect)
+		}
+	}
+}
+
+func TestFilterPeer(t *testing.T) {
+	key := wgtypes.Key(bytes.Repeat([]byte{0x01}, wgtypes.KeyLen))
+	other := wgtypes.Key(bytes.Repeat([]byte{0x02}, wgtypes.KeyLen)).String()
+	_, ipn, _ := net.ParseCIDR("10.1.2.3/32")
+	peer := &wgtypes.Peer{PublicKey: key, AllowedIPs: []net.IPNet{*ipn}}
+	tags := []string{"customer", "eu"}
+	prefix := func(s string) []netip.Prefix {
+		return []netip.Prefix{netip.MustParsePrefix(s)}
+	}
+
+	testCases := []struct {
+		filter *Filter
+		expect bool
+	}{
+		{nil, true},
+		{&Filter{}, true},
+		{&Filter{Peers: []string{key.String()}}, true},
+		{&Filter{Peers: []string{other}}, false},
+		{&Filter{ExcludePeers: []string{key.String()}}, false},
+		{&Filter{Tags: []string{"staff", "customer"}}, true},
+		{&Filter{Tags: []string{"staff"}}, false},
+		{&Filter{Tags: []string{"customer"}, ExcludeTags: []string{"eu"}}, false},
+		{&Filter{AllowedIPs: prefix("10.1.0.0/16")}, true},
+		{&Filter{AllowedIPs: prefix("10.2.0.0/16")}, false},
+		{&Filter{AllowedIPs: prefix("10.1.2.3/32")}, true},
+		{&Filter{ExcludeAllowedIPs: prefix("10.0.0.0/8")}, false},
+		// all includes must match
+		{&Filter{Peers: []string{key.String()}, Tags: []string{"staff"}}, false},
+	}
+	for i, tc := range testCases {
+		if got := tc.filter.Peer(peer, tags); got != tc.expect {
+			t.Errorf("case #%d %+v, unexpected result: got %v, want %v", i, tc.filter, got, tc.expect)
+		}
+	}
+
+	// wider allowed ips are not within a narrower prefix
+	_, wide, _ := net.ParseCIDR("10.0.0.0/8")
+	peer.AllowedIPs = []net.IPNet{*wide}
+	if (&Filter{AllowedIPs: prefix("10.1.0.0/16")}).Peer(peer, nil) {
+		t.Error("allowed ips wider than prefix should not match")
+	}
+}
+
+func TestFilterDevices(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp", "192.0.2.1:51820")
+	key1 := wgtypes.Key(bytes.Repeat([]byte{0x01}, wgtypes.KeyLen))
+	key2 := wgtypes.Key(bytes.Repeat([]byte{0x02}, wgtypes.KeyLen))
+	devices := []*wgtypes.Device{
+		{Name: "wg-mgmt", Peers: []wgtypes.Peer{{PublicKey: key1, Endpoint: addr}}},
+		{Name: "wg-cust", Peers: []wgtypes.Peer{{PublicKey: key1}, {PublicKey: key2}}},
+	}
+
+	tracker := &Tracker{}
+	tracker.SetPeers(map[string]PeerInfo{"wg-cust:" + key2.String(): {Tags: []string{"test"}}})
+	tracker.filter.Store(&Filter{ExcludeDevices: []string{"wg-mgmt"}, ExcludeTags: []string{"test"}})
+	selected, excluded := tracker.filterDevices(devices)
+	tracker.excluded = excluded
+
+	var got []string
+	for _, dev := range selected {
+		for _, peer := range dev.Peers {
+			got = append(got, dev.Name+":"+peer.PublicKey.String())
+		}
+	}
+	if want := []string{"wg-cust:" + key1.String()}; !slices.Equal(got, want) {
+		t.Errorf("unexpected peers: got %v, want %v", got, want)
+	}
+	if got, want := len(devices[1].Peers), 2; got != want {
+		t.Errorf("snapshot modified: got %d peers, want %d", got, want)
+	}
+	for _, key := range []string{"192.0.2.1:51820", "wg-mgmt:" + key1.String(), "wg-cust:" + key2.String()} {
+		if !tracker.isExcluded(key) {
+			t.Errorf("%s should be excluded", key)
+		}
+	}
+	if tracker.isExcluded("wg-cust:" + key1.String()) {
+		t.Error("selected peer should not be excluded")
+	}
+}
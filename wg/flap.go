@@ -0,0 +1,120 @@
+package wg
+
+import (
+	"sync"
+	"time"
+
+	"github.com/turekt/wgmon/hook"
+)
+
+// FlapDetector filters connection state events. Peer changing state
+// threshold times within window is marked as flapping, reported once and
+// further state changes are suppressed until the peer stays in the same
+// state for the whole window, after which it is reported as stabilized.
+// Cooldown sets minimal time between two notifications about the same
+// peer; state changes within cooldown are held back and the latest one is
+// reported once cooldown expires if it differs from the reported state.
+type FlapDetector struct {
+	mu        sync.Mutex
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	peers     map[string]*flapState
+}
+
+type flapState struct {
+	transitions []time.Time
+	flapping    bool
+	last        *hook.Event
+	notified    time.Time
+	notifiedAs  string
+}
+
+func NewFlapDetector(threshold int, window, cooldown time.Duration) *FlapDetector {
+	return &FlapDetector{
+		threshold: threshold,
+		window:    window,
+		cooldown:  cooldown,
+		peers:     make(map[string]*flapState),
+	}
+}
+
+// Filter returns event which should be reported for the state event e or
+// nil if the notification is suppressed.
+func (f *FlapDetector) Filter(e *hook.Event) *hook.Event {
+	if f == nil || (f.threshold <= 0 && f.cooldown <= 0) {
+		return e
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.peers[e.ID()]
+	if !ok {
+		p = &flapState{}
+		f.peers[e.ID()] = p
+	}
+	p.last = e
+	p.transitions = append(f.recent(p.transitions, e.Time), e.Time)
+
+	if p.flapping {
+		return nil
+	}
+	if f.threshold > 0 && len(p.transitions) >= f.threshold {
+		p.flapping = true
+		p.notified, p.notifiedAs = e.Time, e.State
+		return hook.NewFlappingEvent(e, len(p.transitions))
+	}
+	if f.cooldown > 0 && !p.notified.IsZero() && e.Time.Sub(p.notified) < f.cooldown {
+		return nil
+	}
+	p.notified, p.notifiedAs = e.Time, e.State
+	return e
+}
+
+// Settle returns events for flapping peers which stabilized and for peers
+// whose state changed during an expired cooldown.
+func (f *FlapDetector) Settle(now time.Time) []*hook.Event {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var events []*hook.Event
+	for _, id := range sortedKeys(f.peers) {
+		p := f.peers[id]
+		p.transitions = f.recent(p.transitions, now)
+		switch {
+		case p.flapping && len(p.transitions) == 0:
+			p.flapping = false
+			p.notified, p.notifiedAs = now, p.last.State
+			events = append(events, hook.NewStabilizedEvent(p.last))
+		case !p.flapping && p.last.State != p.notifiedAs && now.Sub(p.notified) >= f.cooldown:
+			p.notified, p.notifiedAs = now, p.last.State
+			events = append(events, p.last)
+		}
+
+		if !p.flapping && len(p.transitions) == 0 && now.Sub(p.notified) >= f.cooldown {
+			delete(f.peers, id)
+		}
+	}
+	return events
+}
+
+// Pending reports whether some peers still await settlement.
+func (f *FlapDetector) Pending() bool {
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.peers) > 0
+}
+
+func (f *FlapDetector) recent(transitions []time.Time, now time.Time) []time.Time {
+	i := 0
+	for i < len(transitions) && now.Sub(transitions[i]) >= f.window {
+		i++
+	}
+	return transitions[i:]
+}
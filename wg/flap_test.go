@@ -0,0 +1,84 @@
+package wg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/turekt/wgmon/hook"
+)
+
+func TestFlapDetector(t *testing.T) {
+	start := time.Now()
+	at := func(minutes int, state string) *hook.Event {
+		e := hook.NewStateEvent("wg0", "key", "127.0.0.1:1111", state)
+		e.Time = start.Add(time.Duration(minutes) * time.Minute)
+		return e
+	}
+
+	f := NewFlapDetector(3, 10*time.Minute, 0)
+	testCases := []struct {
+		name   string
+		event  *hook.Event
+		expect hook.EventType
+	}{
+		{"first open", at(0, "opened"), hook.EventState},
+		{"first close", at(1, "closed"), hook.EventState},
+		{"threshold reached", at(2, "opened"), hook.EventFlapping},
+		{"suppressed close", at(3, "closed"), hook.EventUndefined},
+		{"suppressed open", at(4, "opened"), hook.EventUndefined},
+	}
+	for i, tc := range testCases {
+		got := hook.EventUndefined
+		if e := f.Filter(tc.event); e != nil {
+			got = e.Type
+		}
+		if want := tc.expect; got != want {
+			t.Errorf("case #%d %s, unexpected event: got %v, want %v", i, tc.name, got, want)
+		}
+	}
+
+	if got := f.Settle(start.Add(10 * time.Minute)); len(got) != 0 {
+		t.Fatalf("unexpected settle within window: %v", got)
+	}
+	settled := f.Settle(start.Add(15 * time.Minute))
+	if got, want := len(settled), 1; got != want {
+		t.Fatalf("unexpected settled count: got %d, want %d", got, want)
+	}
+	if got, want := settled[0].Type, hook.EventStabilized; got != want {
+		t.Errorf("unexpected settled type: got %v, want %v", got, want)
+	}
+	if got, want := settled[0].State, "opened"; got != want {
+		t.Errorf("unexpected settled state: got %v, want %v", got, want)
+	}
+	if f.Pending() {
+		t.Errorf("unexpected pending peers after settle")
+	}
+}
+
+func TestFlapDetectorCooldown(t *testing.T) {
+	start := time.Now()
+	at := func(minutes int, state string) *hook.Event {
+		e := hook.NewStateEvent("wg0", "key", "127.0.0.1:1111", state)
+		e.Time = start.Add(time.Duration(minutes) * time.Minute)
+		return e
+	}
+
+	f := NewFlapDetector(0, time.Minute, 5*time.Minute)
+	if e := f.Filter(at(0, "opened")); e == nil {
+		t.Fatalf("expected first event to pass")
+	}
+	if e := f.Filter(at(1, "closed")); e != nil {
+		t.Fatalf("expected event within cooldown to be suppressed")
+	}
+	if got := f.Settle(start.Add(3 * time.Minute)); len(got) != 0 {
+		t.Fatalf("unexpected settle within cooldown: %v", got)
+	}
+
+	settled := f.Settle(start.Add(6 * time.Minute))
+	if got, want := len(settled), 1; got != want {
+		t.Fatalf("unexpected settled count: got %d, want %d", got, want)
+	}
+	if got, want := settled[0].State, "closed"; got != want {
+		t.Errorf("unexpected held back state: got %v, want %v", got, want)
+	}
+}
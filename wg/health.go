@@ -0,0 +1,101 @@
+package wg
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	HealthDefaultPacketTimeout = 15 * time.Minute
+	HealthDefaultMaxPending    = 100
+)
+
+// HealthCheck is a result of a single tracker self check.
+type HealthCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// SetHealthLimits configures when tracker reports itself unhealthy: no
+// packets received for packetTimeout while peers keep handshaking, or more
+// than maxPending notifications waiting on sinks.
+func (t *Tracker) SetHealthLimits(packetTimeout time.Duration, maxPending int) {
+	if packetTimeout <= 0 {
+		packetTimeout = HealthDefaultPacketTimeout
+	}
+	if maxPending <= 0 {
+		maxPending = HealthDefaultMaxPending
+	}
+	t.packetTimeout.Store(int64(packetTimeout))
+	t.maxPending.Store(int64(maxPending))
+}
+
+// Liveness checks whether tracker is working, failing checks indicate
+// wgmon is broken in a way that requires a restart.
+func (t *Tracker) Liveness() []HealthCheck {
+	return []HealthCheck{
+		t.monitorCheck(),
+		t.packetCheck(),
+		t.notifierCheck(),
+	}
+}
+
+// Readiness checks whether tracker is able to track peers.
+func (t *Tracker) Readiness() []HealthCheck {
+	_, err := t.devices()
+	wgctrl := HealthCheck{Name: "wgctrl", OK: err == nil}
+	if err != nil {
+		wgctrl.Message = err.Error()
+	}
+	return []HealthCheck{t.monitorCheck(), wgctrl}
+}
+
+func (t *Tracker) monitorCheck() HealthCheck {
+	if !t.opened.Load() {
+		return HealthCheck{Name: "monitor", Message: t.currentMonitor().Name() + " monitor is not open"}
+	}
+	return HealthCheck{Name: "monitor", OK: true}
+}
+
+func (t *Tracker) packetCheck() HealthCheck {
+	devices, err := t.devices()
+	if err != nil {
+		// wgctrl failures are reported by readiness
+		return HealthCheck{Name: "packets", OK: true, Message: "handshakes unknown: " + err.Error()}
+	}
+	var handshake time.Time
+	for _, dev := range devices {
+		for _, peer := range dev.Peers {
+			if peer.LastHandshakeTime.After(handshake) {
+				handshake = peer.LastHandshakeTime
+			}
+		}
+	}
+	return packetCheck(time.Now(), time.Unix(0, t.lastPacket.Load()), handshake, time.Duration(t.packetTimeout.Load()))
+}
+
+// packetCheck fails when peers handshake while monitor has not seen any
+// packets for timeout. Without recent handshakes tracker is legitimately
+// idle.
+func packetCheck(now, lastPacket, handshake time.Time, timeout time.Duration) HealthCheck {
+	c := HealthCheck{Name: "packets", OK: true}
+	switch {
+	case now.Sub(handshake) > timeout:
+		c.Message = "idle"
+	case now.Sub(lastPacket) > timeout:
+		c.OK = false
+		c.Message = fmt.Sprintf("no packets received since %s while peers handshake, last handshake %s",
+			lastPacket.Format(time.RFC3339), handshake.Format(time.RFC3339))
+	}
+	return c
+}
+
+func (t *Tracker) notifierCheck() HealthCheck {
+	c := HealthCheck{Name: "notifier", OK: true}
+	if pending, limit := t.notifier.Pending(), int(t.maxPending.Load()); pending > limit {
+		c.OK = false
+		c.Message = fmt.Sprintf("%d notifications pending, limit %d", pending, limit)
+	}
+	return c
+}
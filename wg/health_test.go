@@ -0,0 +1,33 @@
+package wg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPacketCheck(t *testing.T) {
+	now := time.Now()
+	timeout := 15 * time.Minute
+	testCases := []struct {
+		lastPacket time.Time
+		handshake  time.Time
+		expect     bool
+	}{
+		// no peers ever handshaked
+		{now.Add(-time.Hour), time.Time{}, true},
+		// idle, last handshake older than timeout
+		{now.Add(-time.Hour), now.Add(-20 * time.Minute), true},
+		// handshakes seen by monitor
+		{now.Add(-2 * time.Minute), now.Add(-time.Minute), true},
+		// recently opened monitor waiting for next handshake
+		{now.Add(-time.Minute), now.Add(-5 * time.Minute), true},
+		// peers keep handshaking without monitor noticing
+		{now.Add(-20 * time.Minute), now.Add(-time.Minute), false},
+	}
+	for i, tc := range testCases {
+		c := packetCheck(now, tc.lastPacket, tc.handshake, timeout)
+		if got, want := c.OK, tc.expect; got != want {
+			t.Errorf("case #%d, unexpected result: got %v, want %v (%s)", i, got, want, c.Message)
+		}
+	}
+}
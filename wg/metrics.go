@@ -0,0 +1,112 @@
+package wg
+
+import (
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/turekt/wgmon/hook"
+	"github.com/turekt/wgmon/metrics"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+var (
+	monitorPackets = metrics.Default.Counter(
+		"wgmon_monitor_packets_total", "Number of packets received from monitor.", "monitor")
+	snapshotDuration = metrics.Default.Histogram(
+		"wgmon_snapshot_duration_seconds", "Latency of wgctrl device snapshots.", metrics.DefaultBuckets)
+	snapshotErrors = metrics.Default.Counter(
+		"wgmon_snapshot_errors_total", "Number of failed wgctrl device snapshots.")
+
+	peerConnected = metrics.Default.Gauge(
+		"wgmon_peer_connected", "Whether peer is connected according to wgmon.", "device", "peer", "name")
+	peerHandshake = metrics.Default.Gauge(
+		"wgmon_peer_last_handshake_seconds", "UNIX timestamp of the last peer handshake.", "device", "peer", "name")
+	peerReceived = metrics.Default.Counter(
+		"wgmon_peer_receive_bytes_total", "Number of bytes received from peer.", "device", "peer", "name")
+	peerTransmitted = metrics.Default.Counter(
+		"wgmon_peer_transmit_bytes_total", "Number of bytes transmitted to peer.", "device", "peer", "name")
+	peerLocation = metrics.Default.Gauge(
+		"wgmon_peer_location", "Geoip location of peer endpoint, always 1.", "device", "peer", "name", "country", "city", "asn", "org")
+	peerSessions = metrics.Default.Counter(
+		"wgmon_peer_sessions_total", "Number of sessions opened by peer since start.", "device", "peer", "name")
+	peerRoams = metrics.Default.Counter(
+		"wgmon_peer_roams_total", "Number of peer endpoint changes since start.", "device", "peer", "name")
+)
+
+// RegisterMetrics refreshes per peer metrics from a fresh device snapshot
+// whenever registry is scraped.
+func (t *Tracker) RegisterMetrics(r *metrics.Registry) {
+	r.OnScrape(t.collectMetrics)
+}
+
+func (t *Tracker) collectMetrics() {
+	_, peers, err := t.Status()
+	if err != nil {
+		slog.Error("metrics snapshot failed", "error", err)
+		return
+	}
+
+	for _, vec := range []*metrics.Vec{peerConnected, peerHandshake, peerReceived, peerTransmitted, peerLocation} {
+		vec.Reset()
+	}
+	for _, p := range peers {
+		var up, handshake float64
+		if p.Connected {
+			up = 1
+		}
+		if p.LastHandshake != nil {
+			handshake = float64(p.LastHandshake.Unix())
+		}
+		peerConnected.With(p.Device, p.PublicKey, p.Name).Set(up)
+		peerHandshake.With(p.Device, p.PublicKey, p.Name).Set(handshake)
+		peerReceived.With(p.Device, p.PublicKey, p.Name).Set(float64(p.ReceiveBytes))
+		peerTransmitted.With(p.Device, p.PublicKey, p.Name).Set(float64(p.TransmitBytes))
+		if l := p.Location; l != nil {
+			peerLocation.With(p.Device, p.PublicKey, p.Name, l.Country, l.City, strconv.FormatUint(uint64(l.ASN), 10), l.Org).Set(1)
+		}
+	}
+}
+
+// devices retrieves tracked wireguard devices measuring snapshot latency.
+func (t *Tracker) devices() ([]*wgtypes.Device, error) {
+	devices, _, err := t.selectDevices()
+	return devices, err
+}
+
+// selectDevices retrieves tracked wireguard devices same as devices and
+// additionally returns ids and endpoints of peers left out by filter.
+func (t *Tracker) selectDevices() ([]*wgtypes.Device, map[string]bool, error) {
+	start := time.Now()
+	devices, err := t.client.Devices()
+	snapshotDuration.With().Observe(time.Since(start).Seconds())
+	if err != nil {
+		snapshotErrors.With().Inc()
+		return nil, nil, err
+	}
+	selected, excluded := t.filterDevices(devices)
+	return selected, excluded, nil
+}
+
+// trackRoams reports and counts peer endpoint changes between snapshots.
+func (t *Tracker) trackRoams(devices []*wgtypes.Device) {
+	for _, dev := range devices {
+		for _, peer := range dev.Peers {
+			if peer.Endpoint == nil {
+				continue
+			}
+			key := peer.PublicKey.String()
+			id := dev.Name + ":" + key
+			prev, loaded := t.endpoints[id]
+			t.endpoints[id] = peer.Endpoint.String()
+			if loaded && prev != peer.Endpoint.String() {
+				e := hook.NewRoamEvent(dev.Name, key, prev, peer.Endpoint.String())
+				t.enrich(e)
+				peerRoams.With(dev.Name, key, e.Name).Inc()
+				t.checkAnomalies(e)
+				t.record(e)
+				t.notify(e)
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package wg
+
+import (
+	"github.com/turekt/wgmon/geoip"
+	"github.com/turekt/wgmon/hook"
+)
+
+// PeerInfo is human readable metadata of a peer attached to its events.
+type PeerInfo struct {
+	Name  string   `json:"name,omitempty"`
+	Owner string   `json:"owner,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+// SetPeers replaces peer metadata, keyed by public key or device:key when
+// the same key is used on multiple devices.
+func (t *Tracker) SetPeers(peers map[string]PeerInfo) {
+	t.peers.Store(&peers)
+}
+
+// PeerInfo returns metadata of peer on device.
+func (t *Tracker) PeerInfo(device, key string) (PeerInfo, bool) {
+	peers := t.peers.Load()
+	if peers == nil {
+		return PeerInfo{}, false
+	}
+	if info, ok := (*peers)[device+":"+key]; ok {
+		return info, true
+	}
+	info, ok := (*peers)[key]
+	return info, ok
+}
+
+// Locator resolves location of endpoints, implemented by geoip.DB.
+type Locator interface {
+	Lookup(endpoint string) *geoip.Location
+}
+
+// SetLocator enables geoip enrichment of events and peer status, nil
+// disables it.
+func (t *Tracker) SetLocator(l Locator) {
+	if l == nil {
+		t.locator.Store(nil)
+		return
+	}
+	t.locator.Store(&l)
+}
+
+// locate returns location of endpoint, nil when unknown or disabled.
+func (t *Tracker) locate(endpoint string) *geoip.Location {
+	l := t.locator.Load()
+	if l == nil || endpoint == "" {
+		return nil
+	}
+	return (*l).Lookup(endpoint)
+}
+
+func (t *Tracker) enrich(e *hook.Event) {
+	if e.Peer != "" {
+		if info, ok := t.PeerInfo(e.Device, e.Peer); ok {
+			e.Name, e.Owner, e.Tags = info.Name, info.Owner, info.Tags
+		}
+	}
+	if e.Location == nil && e.Type != hook.EventDigest {
+		e.Location = t.locate(e.Endpoint)
+	}
+	for _, child := range e.Events {
+		t.enrich(child)
+	}
+}
@@ -0,0 +1,196 @@
+package wg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/turekt/wgmon/hook"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// trackerState is a checkpoint of opened connections written to disk so a
+// restarted tracker neither re-announces them nor misses their closing.
+type trackerState struct {
+	Saved       time.Time         `json:"saved"`
+	Connections []connState       `json:"connections"`
+	Endpoints   map[string]string `json:"endpoints"`
+}
+
+// connState holds the parts of an opened connection and its last peer
+// snapshot needed to compute connection state, keys are not persisted.
+type connState struct {
+	Device        string    `json:"device"`
+	PublicKey     string    `json:"public_key"`
+	Endpoint      string    `json:"endpoint"`
+	LastHandshake time.Time `json:"last_handshake"`
+	ReceiveBytes  int64     `json:"receive_bytes"`
+	TransmitBytes int64     `json:"transmit_bytes"`
+	Since         time.Time `json:"since"`
+}
+
+// SetStatePath enables checkpointing of connection state to path, it has
+// to be called before Start.
+func (t *Tracker) SetStatePath(path string) {
+	t.statePath = path
+}
+
+func (t *Tracker) snapshotState() *trackerState {
+	state := &trackerState{Saved: time.Now(), Endpoints: make(map[string]string)}
+	for _, endpoint := range t.connMap.Endpoints() {
+		conn := t.connMap[endpoint]
+		if !conn.Opened() || conn.curr == nil {
+			continue
+		}
+		state.Connections = append(state.Connections, connState{
+			Device:        conn.device,
+			PublicKey:     conn.curr.PublicKey.String(),
+			Endpoint:      endpoint,
+			LastHandshake: conn.curr.LastHandshakeTime,
+			ReceiveBytes:  conn.curr.ReceiveBytes,
+			TransmitBytes: conn.curr.TransmitBytes,
+			Since:         conn.Since(),
+		})
+	}
+	for k, v := range t.endpoints {
+		state.Endpoints[k] = v
+	}
+	return state
+}
+
+// saveState writes checkpoint atomically, a crash never leaves a partially
+// written state behind.
+func (t *Tracker) saveState() {
+	if t.statePath == "" || !t.restored {
+		return
+	}
+	data, err := json.Marshal(t.snapshotState())
+	if err == nil {
+		tmp := t.statePath + ".tmp"
+		if err = os.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, t.statePath)
+		}
+	}
+	if err != nil {
+		slog.Error("failed to save tracker state", "path", t.statePath, "error", err)
+	}
+}
+
+func loadState(path string) (*trackerState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &trackerState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("corrupted state %s: %w", filepath.Base(path), err)
+	}
+	return state, nil
+}
+
+// restoreState reconciles checkpoint with a fresh snapshot. Connections
+// whose peers kept handshaking are restored as opened, the rest is
+// reported closed at their last handshake. Reports whether any connection
+// was restored. Checkpoint that couldn't be reconciled is kept for the
+// next start, it isn't overwritten by saveState.
+func (t *Tracker) restoreState() bool {
+	if t.statePath == "" {
+		return false
+	}
+	state, err := loadState(t.statePath)
+	if err != nil {
+		// unreadable checkpoint is replaced by the next one
+		slog.Error("failed to load tracker state", "path", t.statePath, "error", err)
+		t.restored = true
+		return false
+	}
+	if state == nil {
+		t.restored = true
+		return false
+	}
+	devices, excluded, err := t.selectDevices()
+	if err != nil {
+		slog.Error("failed to restore tracker state", "error", err)
+		return false
+	}
+	t.excluded = excluded
+	t.restored = true
+
+	for k, v := range state.Endpoints {
+		t.endpoints[k] = v
+	}
+	peers := make(map[string]*wgtypes.Peer)
+	for _, dev := range devices {
+		for i := range dev.Peers {
+			peers[dev.Name+":"+dev.Peers[i].PublicKey.String()] = &dev.Peers[i]
+		}
+	}
+
+	restored := 0
+	for _, cs := range state.Connections {
+		if t.isExcluded(cs.Device + ":" + cs.PublicKey) {
+			continue
+		}
+		conn, closed, err := reconcile(cs, peers[cs.Device+":"+cs.PublicKey], time.Now())
+		if err != nil {
+			slog.Error("skipping invalid connection state", "peer", cs.PublicKey, "error", err)
+			continue
+		}
+		if closed != nil {
+			closed.Missed = true
+			t.notifyState(closed)
+			continue
+		}
+		key := cs.Endpoint
+		if conn.curr.Endpoint != nil {
+			key = conn.curr.Endpoint.String()
+		}
+		t.connMap[key] = conn
+		restored++
+	}
+	slog.Info("restored tracker state", "saved", state.Saved, "restored", restored, "connections", len(state.Connections))
+	return restored > 0
+}
+
+// reconcile returns restored connection of a checkpointed connection, or
+// closed event when the peer is gone or stopped handshaking while tracker
+// was not running.
+func reconcile(cs connState, peer *wgtypes.Peer, now time.Time) (*Connection, *hook.Event, error) {
+	key, err := wgtypes.ParseKey(cs.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	saved := &wgtypes.Peer{
+		PublicKey:         key,
+		LastHandshakeTime: cs.LastHandshake,
+		ReceiveBytes:      cs.ReceiveBytes,
+		TransmitBytes:     cs.TransmitBytes,
+	}
+	saved.Endpoint, _ = net.ResolveUDPAddr("udp", cs.Endpoint)
+	conn := &Connection{device: cs.Device, curr: saved, opened: true, since: cs.Since}
+
+	if peer == nil || peer.LastHandshakeTime.Before(now.Add(-idleTimeout)) {
+		if peer != nil {
+			saved.LastHandshakeTime = peer.LastHandshakeTime
+			saved.ReceiveBytes, saved.TransmitBytes = peer.ReceiveBytes, peer.TransmitBytes
+		}
+		e := conn.Event(cs.Endpoint, ConnectionClosed)
+		e.Time = saved.LastHandshakeTime
+		return nil, e, nil
+	}
+	// peer may have roamed while tracker was down, roaming is reported by
+	// the next snapshot from restored endpoints
+	if peer.Endpoint != nil {
+		saved.Endpoint = peer.Endpoint
+	}
+	return conn, nil, nil
+}
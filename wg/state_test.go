@@ -0,0 +1,126 @@
+package wg
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestReconcile(t *testing.T) {
+	now := time.Now()
+	key, _ := wgtypes.GeneratePrivateKey()
+	cs := connState{
+		Device:        "wg0",
+		PublicKey:     key.PublicKey().String(),
+		Endpoint:      "1.1.1.1:1000",
+		LastHandshake: now.Add(-time.Hour),
+		Since:         now.Add(-2 * time.Hour),
+	}
+	peer := func(handshake time.Time, endpoint string) *wgtypes.Peer {
+		addr, _ := net.ResolveUDPAddr("udp", endpoint)
+		return &wgtypes.Peer{PublicKey: key.PublicKey(), Endpoint: addr, LastHandshakeTime: handshake, ReceiveBytes: 10}
+	}
+	testCases := []struct {
+		peer     *wgtypes.Peer
+		restored string
+		closed   time.Time
+	}{
+		// peer removed while tracker was down
+		{nil, "", cs.LastHandshake},
+		// peer stopped handshaking while tracker was down
+		{peer(now.Add(-30*time.Minute), "1.1.1.1:1000"), "", now.Add(-30 * time.Minute)},
+		{peer(now.Add(-time.Minute), "1.1.1.1:1000"), "1.1.1.1:1000", time.Time{}},
+		// roamed while tracker was down
+		{peer(now.Add(-time.Minute), "2.2.2.2:2000"), "2.2.2.2:2000", time.Time{}},
+	}
+	for i, tc := range testCases {
+		conn, closed, err := reconcile(cs, tc.peer, now)
+		if err != nil {
+			t.Fatalf("case #%d, unexpected error: %v", i, err)
+		}
+		if tc.restored != "" {
+			if conn == nil || closed != nil {
+				t.Errorf("case #%d, expected restored connection, got closed %v", i, closed)
+				continue
+			}
+			if got, want := conn.curr.Endpoint.String(), tc.restored; got != want {
+				t.Errorf("case #%d, unexpected endpoint: got %v, want %v", i, got, want)
+			}
+			if !conn.Opened() || !conn.Since().Equal(cs.Since) {
+				t.Errorf("case #%d, connection not restored as opened since %v", i, cs.Since)
+			}
+			continue
+		}
+		if closed == nil {
+			t.Errorf("case #%d, expected closed event", i)
+			continue
+		}
+		if got, want := closed.State, ConnectionClosed.String(); got != want {
+			t.Errorf("case #%d, unexpected state: got %v, want %v", i, got, want)
+		}
+		if got, want := closed.Time, tc.closed; !got.Equal(want) {
+			t.Errorf("case #%d, unexpected close time: got %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestSaveState(t *testing.T) {
+	key, _ := wgtypes.GeneratePrivateKey()
+	since := time.Now().Add(-time.Hour).Round(0)
+	tracker := &Tracker{
+		connMap:   NewConnectionMap(),
+		endpoints: make(map[string]string),
+		statePath: filepath.Join(t.TempDir(), "state.json"),
+		restored:  true,
+	}
+	tracker.connMap["1.1.1.1:1000"] = &Connection{
+		device: "wg0",
+		curr:   &wgtypes.Peer{PublicKey: key.PublicKey(), ReceiveBytes: 5},
+		opened: true,
+		since:  since,
+	}
+	tracker.connMap["2.2.2.2:2000"] = &Connection{device: "wg0", curr: &wgtypes.Peer{}}
+	tracker.endpoints["wg0:"+key.PublicKey().String()] = "1.1.1.1:1000"
+	tracker.saveState()
+
+	state, err := loadState(tracker.statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(state.Connections), 1; got != want {
+		t.Fatalf("unexpected connections: got %d, want %d", got, want)
+	}
+	cs := state.Connections[0]
+	if cs.Endpoint != "1.1.1.1:1000" || cs.ReceiveBytes != 5 || !cs.Since.Equal(since) {
+		t.Errorf("unexpected connection state: %+v", cs)
+	}
+	if got, want := state.Endpoints["wg0:"+key.PublicKey().String()], "1.1.1.1:1000"; got != want {
+		t.Errorf("unexpected endpoint: got %v, want %v", got, want)
+	}
+}
+
+func TestSaveStateNotRestored(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	want := []byte(`{"connections":[{"device":"wg0","endpoint":"1.1.1.1:1000"}]}`)
+	if err := os.WriteFile(path, want, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// tracker stopped before it started never reconciled the checkpoint
+	tracker := newTracker(&testClient{}, newTestPoller())
+	tracker.SetStatePath(path)
+	tracker.Stop()
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("state overwritten: got %s, want %s", got, want)
+	}
+}
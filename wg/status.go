@@ -0,0 +1,114 @@
+package wg
+
+import (
+	"time"
+
+	"github.com/turekt/wgmon/geoip"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+type DeviceStatus struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	PublicKey  string `json:"public_key"`
+	ListenPort int    `json:"listen_port"`
+	Peers      int    `json:"peers"`
+	Connected  int    `json:"connected"`
+}
+
+type PeerStatus struct {
+	Device    string `json:"device"`
+	PublicKey string `json:"public_key"`
+	PeerInfo
+	Endpoint      string     `json:"endpoint,omitempty"`
+	AllowedIPs    []string   `json:"allowed_ips"`
+	State         string     `json:"state"`
+	Connected     bool       `json:"connected"`
+	LastHandshake *time.Time `json:"last_handshake,omitempty"`
+	ReceiveBytes  int64      `json:"receive_bytes"`
+	TransmitBytes int64      `json:"transmit_bytes"`
+	SessionStart  *time.Time `json:"session_start,omitempty"`
+
+	Location *geoip.Location `json:"location,omitempty"`
+}
+
+// ID returns peer identifier in device:key form.
+func (p *PeerStatus) ID() string {
+	return p.Device + ":" + p.PublicKey
+}
+
+// Status returns current devices and peers from a fresh snapshot combined
+// with connection state computed by tracker. Snapshot is not stored so
+// status queries do not influence connection tracking.
+func (t *Tracker) Status() ([]DeviceStatus, []PeerStatus, error) {
+	devices, err := t.devices()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// copies of connections are read outside of the event loop, peer
+	// snapshots they point to are never modified
+	conns := make(map[string]*Connection)
+	if !t.do(func() {
+		for _, conn := range t.connMap {
+			if conn.curr != nil {
+				c := *conn
+				conns[conn.ID()] = &c
+			}
+		}
+	}) {
+		return nil, nil, ErrStopped
+	}
+
+	var devs []DeviceStatus
+	var peers []PeerStatus
+	for _, dev := range devices {
+		ds := DeviceStatus{
+			Name:       dev.Name,
+			Type:       dev.Type.String(),
+			PublicKey:  dev.PublicKey.String(),
+			ListenPort: dev.ListenPort,
+			Peers:      len(dev.Peers),
+		}
+		for _, peer := range dev.Peers {
+			ps := newPeerStatus(dev.Name, &peer, conns[dev.Name+":"+peer.PublicKey.String()])
+			ps.PeerInfo, _ = t.PeerInfo(dev.Name, ps.PublicKey)
+			ps.Location = t.locate(ps.Endpoint)
+			if ps.Connected {
+				ds.Connected++
+			}
+			peers = append(peers, ps)
+		}
+		devs = append(devs, ds)
+	}
+	return devs, peers, nil
+}
+
+func newPeerStatus(device string, peer *wgtypes.Peer, conn *Connection) PeerStatus {
+	ps := PeerStatus{
+		Device:        device,
+		PublicKey:     peer.PublicKey.String(),
+		AllowedIPs:    make([]string, 0, len(peer.AllowedIPs)),
+		State:         ConnectionUndefined.String(),
+		ReceiveBytes:  peer.ReceiveBytes,
+		TransmitBytes: peer.TransmitBytes,
+	}
+	if peer.Endpoint != nil {
+		ps.Endpoint = peer.Endpoint.String()
+	}
+	for _, ipn := range peer.AllowedIPs {
+		ps.AllowedIPs = append(ps.AllowedIPs, ipn.String())
+	}
+	if !peer.LastHandshakeTime.IsZero() {
+		handshake := peer.LastHandshakeTime
+		ps.LastHandshake = &handshake
+	}
+	if conn != nil {
+		ps.State = conn.Peek().String()
+		ps.Connected = conn.Opened()
+		if since := conn.Since(); !since.IsZero() {
+			ps.SessionStart = &since
+		}
+	}
+	return ps
+}
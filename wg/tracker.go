@@ -1,165 +1,487 @@
 package wg
 
 import (
+	"errors"
 	"log/slog"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/google/gopacket"
 	"github.com/turekt/wgmon/hook"
 	"github.com/turekt/wgmon/network"
 	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 var tickInterval = 2 * time.Minute
 
+// ErrStopped is returned by queries of a stopped tracker.
+var ErrStopped = errors.New("tracker stopped")
+
+// deviceClient lists wireguard devices, implemented by wgctrl.Client.
+type deviceClient interface {
+	Devices() ([]*wgtypes.Device, error)
+}
+
+// Tracker reports connection state changes of wireguard peers. All
+// connection state is owned by a single event loop goroutine processing
+// monitor packets, polls, ticks and requests in the order they arrive, so
+// events are emitted in a deterministic order.
 type Tracker struct {
-	client  *wgctrl.Client
-	connMap *ConnectionMap
-	monitor network.Monitor
-	webhook string
+	client   deviceClient
+	monitor  network.Monitor
+	notifier *hook.Notifier
+	flaps    atomic.Pointer[FlapDetector]
+	attempts *AttemptDetector
+	recorder atomic.Pointer[SessionRecorder]
+	locator  atomic.Pointer[Locator]
+	anomaly  atomic.Pointer[AnomalyDetector]
+
+	// file where connection state is checkpointed, disabled when empty
+	statePath string
+
+	// monitorMu guards monitor replaced on configuration reload
+	monitorMu sync.RWMutex
+
+	// selection of tracked devices and peers, everything when nil
+	filter atomic.Pointer[Filter]
+	// peer metadata attached to events by device:key or key
+	peers atomic.Pointer[map[string]PeerInfo]
+
+	// requests run on the event loop, which closes done when it returns
+	// after quit is closed
+	requests chan func()
+	quit     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// state below is owned by the event loop
+	connMap ConnectionMap
 	ticker  *time.Ticker
+	// last known endpoint per peer used for roaming detection
+	endpoints map[string]string
+	// endpoints and ids of peers left out by filter in the last snapshot
+	excluded map[string]bool
+	// checkpoint was reconciled by restoreState, saving before that would
+	// overwrite connections closed while tracker was not running
+	restored bool
+
+	// health state, lastPacket holds UNIX nanoseconds of last packet
+	// received from monitor or time when monitor was opened
+	opened        atomic.Bool
+	lastPacket    atomic.Int64
+	packetTimeout atomic.Int64
+	maxPending    atomic.Int64
 }
 
-func NewTracker(monitor network.Monitor, webhook string) (*Tracker, error) {
+func NewTracker(monitor network.Monitor, sinks ...hook.Sink) (*Tracker, error) {
 	w, err := wgctrl.New()
 	if err != nil {
 		return nil, err
 	}
+	return newTracker(w, monitor, sinks...), nil
+}
+
+// newTracker creates tracker and starts its event loop, packets are
+// processed once watching starts.
+func newTracker(client deviceClient, monitor network.Monitor, sinks ...hook.Sink) *Tracker {
+	t := &Tracker{
+		client:    client,
+		connMap:   NewConnectionMap(),
+		endpoints: make(map[string]string),
+		monitor:   monitor,
+		notifier:  hook.NewNotifier(sinks...),
+		attempts:  NewAttemptDetector(AttemptsDefaultInterval),
+		requests:  make(chan func()),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	t.SetHealthLimits(HealthDefaultPacketTimeout, HealthDefaultMaxPending)
+	go t.loop()
+	return t
+}
+
+// SetFlapDetector enables flap detection and notification cooldowns of
+// connection state events.
+func (t *Tracker) SetFlapDetector(f *FlapDetector) {
+	t.flaps.Store(f)
+}
+
+// SessionRecorder receives every connection state change and roam, including
+// state changes suppressed by flap detection.
+type SessionRecorder interface {
+	Record(e *hook.Event)
+}
+
+// SetSessionRecorder registers r to receive connection state changes, nil
+// disables recording.
+func (t *Tracker) SetSessionRecorder(r SessionRecorder) {
+	if r == nil {
+		t.recorder.Store(nil)
+		return
+	}
+	t.recorder.Store(&r)
+}
+
+func (t *Tracker) record(e *hook.Event) {
+	if r := t.recorder.Load(); r != nil {
+		t.enrich(e)
+		(*r).Record(e)
+	}
+}
+
+// AnomalyDetector compares connection state changes and roams with peer
+// history and returns anomaly events to notify.
+type AnomalyDetector interface {
+	Check(e *hook.Event) []*hook.Event
+}
+
+// SetAnomalyDetector enables anomaly alerts, nil disables them.
+func (t *Tracker) SetAnomalyDetector(d AnomalyDetector) {
+	if d == nil {
+		t.anomaly.Store(nil)
+		return
+	}
+	t.anomaly.Store(&d)
+}
+
+// checkAnomalies notifies anomalies of e, it has to be called before e is
+// recorded so that history holds only previous sessions.
+func (t *Tracker) checkAnomalies(e *hook.Event) {
+	if d := t.anomaly.Load(); d != nil {
+		for _, a := range (*d).Check(e) {
+			t.notify(a)
+		}
+	}
+}
 
-	return &Tracker{
-		client:  w,
-		connMap: NewConnectionMap(),
-		monitor: monitor,
-		webhook: webhook,
-		ticker:  nil,
-	}, nil
+func (t *Tracker) notifyState(e *hook.Event) {
+	if e.State == ConnectionOpened.String() {
+		t.enrich(e)
+		peerSessions.With(e.Device, e.Peer, e.Name).Inc()
+		t.checkAnomalies(e)
+	}
+	t.record(e)
+	if e = t.flaps.Load().Filter(e); e != nil {
+		t.notify(e)
+	}
+}
+
+// notify attaches peer metadata to event and passes it to sinks.
+func (t *Tracker) notify(e *hook.Event) {
+	t.enrich(e)
+	t.notifier.Notify(e)
+}
+
+// Notify passes event raised outside tracker to its sinks, ordered with
+// events of the tracker.
+func (t *Tracker) Notify(e *hook.Event) {
+	if !t.do(func() { t.notify(e) }) {
+		t.notify(e)
+	}
+}
+
+// AddSink registers an additional destination for tracker events.
+func (t *Tracker) AddSink(s hook.Sink) {
+	t.notifier.Add(s)
+}
+
+// SetSinks replaces all destinations of tracker events, replaced sinks
+// are closed once events queued for them are sent.
+func (t *Tracker) SetSinks(sinks []hook.Sink) {
+	t.notifier.Set(sinks)
+}
+
+// do runs f on the event loop and waits until it returns. It reports false
+// without running f when tracker is stopped.
+func (t *Tracker) do(f func()) bool {
+	ran := make(chan struct{})
+	select {
+	case t.requests <- func() { f(); close(ran) }:
+		<-ran
+		return true
+	case <-t.done:
+		return false
+	}
+}
+
+// loop owns connection state until tracker is stopped. Packets and polls
+// are read from the current monitor, a monitor whose channels close is
+// either replaced or has stopped.
+func (t *Tracker) loop() {
+	defer close(t.done)
+	var stopped network.Monitor
+	for {
+		m := t.currentMonitor()
+		var packets chan gopacket.Packet
+		var polls chan time.Time
+		if m != stopped {
+			packets = m.PacketChan()
+			if p, ok := m.(network.Poller); ok {
+				polls = p.PollChan()
+			}
+		}
+		var ticks <-chan time.Time
+		if t.ticker != nil {
+			ticks = t.ticker.C
+		}
+
+		select {
+		case i, ok := <-packets:
+			if !ok {
+				stopped = t.closedMonitor(m)
+				continue
+			}
+			t.handleMonitorPacket(m, i)
+		case now, ok := <-polls:
+			if !ok {
+				stopped = t.closedMonitor(m)
+				continue
+			}
+			t.handlePoll(m.(network.Poller), now)
+		case tick := <-ticks:
+			t.handleTick(tick)
+		case f := <-t.requests:
+			f()
+		case <-t.quit:
+			t.stopTicker()
+			t.saveState()
+			return
+		}
+	}
+}
+
+// closedMonitor returns m when it stopped on its own, nil when it was
+// replaced.
+func (t *Tracker) closedMonitor(m network.Monitor) network.Monitor {
+	if m != t.currentMonitor() {
+		return nil
+	}
+	select {
+	case <-t.quit:
+	default:
+		slog.Error("monitor stopped", "monitor", m.Name())
+		t.opened.Store(false)
+	}
+	return m
 }
 
 func (t *Tracker) connSnapshot() error {
-	devices, err := t.client.Devices()
+	devices, excluded, err := t.selectDevices()
 	if err != nil {
 		return err
 	}
+	t.excluded = excluded
 
+	t.trackRoams(devices)
 	t.connMap.Snapshot(devices)
 	return nil
 }
 
 func (t *Tracker) reportNewConn() {
-	t.connMap.Range(func(k, v any) bool {
-		conn := v.(*Connection)
-		switch s := conn.State(); s {
-		case ConnectionOpened:
-			go func() {
-				if err := hook.PostState(t.webhook, k.(string), conn.ID(), s.String()); err != nil {
-					slog.Error("post state error on conn change", "state", s, "error", err)
-				}
-			}()
+	for _, endpoint := range t.connMap.Endpoints() {
+		conn := t.connMap[endpoint]
+		if s := conn.State(); s == ConnectionOpened {
+			t.notifyState(conn.Event(endpoint, s))
 		}
-		return true
-	})
+	}
 }
 
+// initTicker starts checking connections every tickInterval.
 func (t *Tracker) initTicker() {
-	// initiate ticker that checks connections every n minutes
 	t.ticker = time.NewTicker(tickInterval)
+}
 
-	go func() {
-		for tick := range t.ticker.C {
-			slog.Info("tick", "time", tick)
-			if err := t.connSnapshot(); err != nil {
-				slog.Error("wg show error", "error", err)
-			}
+func (t *Tracker) stopTicker() {
+	if t.ticker != nil {
+		t.ticker.Stop()
+		t.ticker = nil
+	}
+}
 
-			// each conn is checked for potential closure and removed
-			var connCount atomic.Int32
-			t.connMap.Range(func(k, v any) bool {
-				connCount.Add(1)
-				conn := v.(*Connection)
-				switch s := conn.State(); s {
-				case ConnectionClosed:
-					go func() {
-						if err := hook.PostState(t.webhook, k.(string), conn.ID(), s.String()); err != nil {
-							slog.Error("post state error during tick", "error", err)
-						}
-					}()
-					fallthrough
-				case ConnectionInactive:
-					t.connMap.Delete(k)
-					connCount.Add(-1)
-				}
-				return true
-			})
-
-			// if there is nothing in connection map then stop ticker
-			// no one is connected
-			if connCount.Load() == 0 {
-				slog.Info("stopping ticker")
-				t.ticker.Stop()
-				t.ticker = nil
+func (t *Tracker) handleTick(tick time.Time) {
+	slog.Info("tick", "time", tick)
+	if err := t.connSnapshot(); err != nil {
+		slog.Error("wg show error", "error", err)
+	}
+
+	connCount := t.updateConns(false)
+	t.settle(tick)
+
+	// if there is nothing in connection map then stop ticker
+	// no one is connected
+	if connCount == 0 && !t.flaps.Load().Pending() && !t.attempts.Pending() {
+		slog.Info("stopping ticker")
+		t.stopTicker()
+	}
+}
+
+// updateConns checks each connection for closure, notifying closed ones
+// and, when reportOpened is set, newly opened ones. Closed and inactive
+// connections are removed, the number of remaining ones is returned.
+func (t *Tracker) updateConns(reportOpened bool) int {
+	for _, endpoint := range t.connMap.Endpoints() {
+		conn := t.connMap[endpoint]
+		switch s := conn.State(); s {
+		case ConnectionOpened:
+			if reportOpened {
+				t.notifyState(conn.Event(endpoint, s))
 			}
+		case ConnectionClosed:
+			t.notifyState(conn.Event(endpoint, s))
+			fallthrough
+		case ConnectionInactive:
+			delete(t.connMap, endpoint)
 		}
-	}()
-}
-
-func (t *Tracker) handlePacket() {
-	for i := range t.monitor.PacketChan() {
-		details := network.NewPacketDetails(i)
-		if t.ticker == nil {
-			// report this initial packet
-			go func() {
-				if err := hook.PostPacketDetails(t.webhook, details); err != nil {
-					slog.Error("post packet details error", "error", err)
-				}
-			}()
-			t.initTicker()
-		}
+	}
+	return len(t.connMap)
+}
 
-		if conn, ok := t.connMap.Load(details.RemoteAddr()); ok && conn.(*Connection).Opened() {
-			// if opened, connection is already reported
-			continue
-		}
+// settle reports flapping peers that settled down and failed connection
+// attempts, and checkpoints connection state.
+func (t *Tracker) settle(now time.Time) {
+	for _, e := range t.flaps.Load().Settle(now) {
+		t.notify(e)
+	}
+	t.reportAttempts(now)
+	t.saveState()
+}
+
+func (t *Tracker) handleMonitorPacket(m network.Monitor, i gopacket.Packet) {
+	monitorPackets.With(m.Name()).Inc()
+	t.lastPacket.Store(time.Now().UnixNano())
+	details := network.NewPacketDetails(i)
+	if t.ticker == nil {
+		// report this initial packet
+		t.notify(hook.NewPacketEvent(details))
+		t.initTicker()
+	}
+
+	if conn, ok := t.connMap[details.RemoteAddr()]; ok && conn.Opened() {
+		// if opened, connection is already reported
+		return
+	}
+	if t.isExcluded(details.RemoteAddr()) {
+		// peer is not tracked
+		return
+	}
+
+	// snapshot wg show all dump
+	if err := t.connSnapshot(); err != nil {
+		slog.Error("wg show data failed", "error", err)
+		return
+	}
+	t.reportNewConn()
+
+	if _, ok := t.connMap[details.RemoteAddr()]; !ok && !t.isExcluded(details.RemoteAddr()) {
+		// source is not an endpoint of any peer, yet
+		t.attempts.Add(details, network.IsHandshakeInitiation(i))
+	}
+}
+
+// handlePoll does on each poll what packets and ticks do together in
+// packet modes: snapshot devices, report opened and closed connections and
+// settle pending events.
+func (t *Tracker) handlePoll(p network.Poller, now time.Time) {
+	t.lastPacket.Store(now.UnixNano())
+	// left running by previous packet monitor
+	t.stopTicker()
+	if err := t.connSnapshot(); err != nil {
+		slog.Error("wg show data failed", "error", err)
+		p.Polled(false)
+		return
+	}
+	t.updateConns(true)
+	t.settle(now)
 
-		// snapshot wg show all dump
-		if err := t.connSnapshot(); err != nil {
-			slog.Error("wg show data failed", "error", err)
-			continue
+	idle := true
+	for _, conn := range t.connMap {
+		if conn.Opened() {
+			idle = false
+			break
 		}
-		t.reportNewConn()
 	}
+	p.Polled(idle)
 }
 
+func (t *Tracker) currentMonitor() network.Monitor {
+	t.monitorMu.RLock()
+	defer t.monitorMu.RUnlock()
+	return t.monitor
+}
+
+// ReplaceMonitor opens m and switches packet processing over to it before
+// closing the current monitor. Tracked connections are kept. When m can't
+// be opened the current monitor stays in use.
+func (t *Tracker) ReplaceMonitor(m network.Monitor) error {
+	if err := m.Open(); err != nil {
+		return err
+	}
+	go m.Watch()
+
+	// swapped on the event loop, so it stops waiting on channels of the
+	// current monitor, which never close when it failed to open
+	var old network.Monitor
+	swapped := t.do(func() {
+		t.monitorMu.Lock()
+		old = t.monitor
+		t.monitor = m
+		t.monitorMu.Unlock()
+	})
+	if !swapped {
+		m.Close()
+		return ErrStopped
+	}
+
+	slog.Info("replaced monitor", "old", old.Name(), "new", m.Name())
+	old.Close()
+	t.opened.Store(true)
+	return nil
+}
+
+// Start watches monitor and blocks until tracker is stopped.
 func (t *Tracker) Start() {
-	t.startWatch(t.monitor.Watch)
+	t.startWatch(network.Monitor.Watch)
 }
 
-func (t *Tracker) startWatch(watchFunc func()) error {
-	if err := t.monitor.Open(); err != nil {
+func (t *Tracker) startWatch(watchFunc func(network.Monitor)) error {
+	// monitor may be replaced by a concurrent reload
+	m := t.currentMonitor()
+	if err := m.Open(); err != nil {
 		slog.Error("error opening handle", "error", err)
 		return err
 	}
+	t.lastPacket.Store(time.Now().UnixNano())
+	t.opened.Store(true)
+	t.do(func() {
+		_, polling := m.(network.Poller)
+		if t.restoreState() && !polling {
+			// restored connections are checked for closing without
+			// waiting for the next packet
+			t.initTicker()
+		}
+	})
 
-	go watchFunc()
-	slog.Info("initiating wg peer monitoring", "monitor", t.monitor)
-	t.handlePacket()
+	go watchFunc(m)
+	slog.Info("initiating wg peer monitoring", "monitor", m.Name())
+	<-t.done
 	return nil
 }
 
+// Stop closes monitor and waits for the event loop to checkpoint
+// connection state before closing sinks.
 func (t *Tracker) Stop() {
-	ct := make(chan byte, 1)
-	go func() {
-		t.monitor.Close()
-		ct <- byte(0x01)
-	}()
-
-	select {
-	case <-ct:
-	case <-time.After(3 * time.Second):
-		t.monitor.ShutdownChan() <- byte(0x01)
-	}
+	t.opened.Store(false)
+	t.stopOnce.Do(func() {
+		close(t.quit)
+	})
+	t.currentMonitor().Close()
+	<-t.done
+	t.notifier.Close()
+}
 
-	if t.ticker != nil {
-		t.ticker.Stop()
-		t.ticker = nil
-	}
+// NotifyTest sends a test event to every sink and reports the results.
+func (t *Tracker) NotifyTest() []hook.SendResult {
+	return t.notifier.Test(hook.NewTestEvent())
 }
@@ -1,7 +1,9 @@
 package wg
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -9,13 +11,17 @@ import (
 	"net"
 	"net/http"
 	"runtime"
-	"sync/atomic"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/gopacket"
 	"github.com/google/nftables"
 	"github.com/google/nftables/binaryutil"
 	"github.com/google/nftables/expr"
+	"github.com/turekt/wgmon/hook"
 	"github.com/turekt/wgmon/network"
 	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netns"
@@ -90,7 +96,7 @@ func NewNetwork(nameA, vethIPA, nameB, vethIPB string) (*Network, error) {
 	n := &Network{&NetworkPeer{}, &NetworkPeer{}}
 	retErr := func(strfmt string, a ...any) error {
 		n.Clean()
-		return fmt.Errorf(strfmt, a)
+		return fmt.Errorf(strfmt, a...)
 	}
 	ns1, err := netns.NewNamed(nameA + NsNameExtension)
 	if err != nil {
@@ -361,10 +367,12 @@ func run(n *Network, mType string, ckey, skey wgtypes.Key) error {
 		ns := int(*n.PeerA.Ns())
 		addNftablesLogRule(group, ns)
 		monitor = network.NewNFLogMonitor(group, ns)
+	case "poll":
+		monitor = network.NewPollMonitor(500*time.Millisecond, time.Second)
 	default:
 		monitor = network.NewBPFMonitor(ServerVethName, fmt.Sprintf("udp and dst port %d", ServerWireGuardPort))
 	}
-	tracker, err := NewTracker(monitor, fmt.Sprintf("http://%s:%s/echo", ServerWireGuardIP, ServerHTTPPort))
+	tracker, err := NewTracker(monitor, hook.NewWebhookSink(fmt.Sprintf("http://%s:%s/echo", ServerWireGuardIP, ServerHTTPPort)))
 	if err != nil {
 		return fmt.Errorf("failed to init tracker: %v", err)
 	}
@@ -389,9 +397,9 @@ func run(n *Network, mType string, ckey, skey wgtypes.Key) error {
 	go func() {
 		// start watch under veth0
 		n.PeerA.Set()
-		tracker.startWatch(func() {
+		tracker.startWatch(func(m network.Monitor) {
 			n.PeerA.Set()
-			tracker.monitor.Watch()
+			m.Watch()
 		})
 	}()
 	time.Sleep(1 * time.Second)
@@ -413,15 +421,18 @@ func run(n *Network, mType string, ckey, skey wgtypes.Key) error {
 	time.Sleep(2 * time.Second)
 
 	// check live peers
-	counter := func() int32 {
-		var connCount atomic.Int32
-		tracker.connMap.Range(func(k, v any) bool {
-			connCount.Add(1)
-			return true
+	counter := func() (count int) {
+		tracker.do(func() {
+			for _, conn := range tracker.connMap {
+				// polls keep adding idle peers until they are found inactive
+				if mType != "poll" || conn.Opened() {
+					count++
+				}
+			}
 		})
-		return connCount.Load()
+		return
 	}
-	if got, want := counter(), int32(1); got != want {
+	if got, want := counter(), 1; got != want {
 		return fmt.Errorf("unexpected first conn count: got %d, want %d", got, want)
 	}
 
@@ -432,7 +443,7 @@ func run(n *Network, mType string, ckey, skey wgtypes.Key) error {
 	time.Sleep(3 * time.Second)
 
 	// check live peers
-	if got, want := counter(), int32(0); got != want {
+	if got, want := counter(), 0; got != want {
 		return fmt.Errorf("unexpected second conn count: got %d, want %d", got, want)
 	}
 
@@ -460,7 +471,7 @@ func TestTracker(t *testing.T) {
 		t.Fatalf("failed generating client key: %v", err)
 	}
 
-	for _, mType := range []string{"nflog", "bpf"} {
+	for _, mType := range []string{"nflog", "bpf", "poll"} {
 		n, err := NewNetwork(ServerVethName, ServerVethIPN, ClientVethName, ClientVethIPN)
 		if err != nil {
 			t.Fatalf("failed creating network: %v", err)
@@ -474,3 +485,173 @@ func TestTracker(t *testing.T) {
 		}
 	}
 }
+
+// testClient returns devices set by the test.
+type testClient struct {
+	mu      sync.Mutex
+	devices []*wgtypes.Device
+}
+
+func (c *testClient) Devices() ([]*wgtypes.Device, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.devices, nil
+}
+
+func (c *testClient) set(devices []*wgtypes.Device) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.devices = devices
+}
+
+// testPoller delivers polls sent by the test and reports back idleness.
+type testPoller struct {
+	C    chan gopacket.Packet
+	P    chan time.Time
+	S    chan byte
+	idle chan bool
+	once sync.Once
+}
+
+func newTestPoller() *testPoller {
+	return &testPoller{
+		C:    make(chan gopacket.Packet),
+		P:    make(chan time.Time),
+		S:    make(chan byte),
+		idle: make(chan bool),
+	}
+}
+
+func (m *testPoller) Name() string                     { return "test" }
+func (m *testPoller) Open() error                      { return nil }
+func (m *testPoller) ShutdownChan() chan byte          { return m.S }
+func (m *testPoller) PacketChan() chan gopacket.Packet { return m.C }
+func (m *testPoller) PollChan() chan time.Time         { return m.P }
+func (m *testPoller) Polled(idle bool)                 { m.idle <- idle }
+func (m *testPoller) Close()                           { m.once.Do(func() { close(m.S) }) }
+
+func (m *testPoller) Watch() {
+	<-m.S
+	close(m.P)
+	close(m.C)
+}
+
+type recordSink struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recordSink) Name() string {
+	return "record"
+}
+
+func (r *recordSink) Send(e *hook.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, strings.Join(strings.Fields(e.Type.String()+" "+e.Endpoint+" "+e.State), " "))
+	return nil
+}
+
+func (r *recordSink) Events() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.events)
+}
+
+func TestTrackerLoop(t *testing.T) {
+	key1 := wgtypes.Key(bytes.Repeat([]byte{0x01}, wgtypes.KeyLen))
+	key2 := wgtypes.Key(bytes.Repeat([]byte{0x02}, wgtypes.KeyLen))
+	addr1, _ := net.ResolveUDPAddr("udp", "192.0.2.2:1000")
+	addr2, _ := net.ResolveUDPAddr("udp", "192.0.2.1:2000")
+	now := time.Now()
+	devices := func(handshake time.Time) []*wgtypes.Device {
+		return []*wgtypes.Device{{Name: "wg0", Peers: []wgtypes.Peer{
+			{PublicKey: key1, Endpoint: addr1, LastHandshakeTime: handshake},
+			{PublicKey: key2, Endpoint: addr2, LastHandshakeTime: handshake},
+		}}}
+	}
+
+	client := &testClient{}
+	monitor := newTestPoller()
+	rec := &recordSink{}
+	tracker := newTracker(client, monitor, rec)
+	started := make(chan struct{})
+	go func() {
+		tracker.startWatch(network.Monitor.Watch)
+		close(started)
+	}()
+
+	// status is queried concurrently with polls
+	queried := make(chan struct{})
+	go func() {
+		defer close(queried)
+		for {
+			if _, _, err := tracker.Status(); err != nil {
+				return
+			}
+		}
+	}()
+
+	testCases := []struct {
+		handshake time.Time
+		idle      bool
+	}{
+		{now.Add(-20 * time.Minute), true},
+		// both handshake, opened
+		{now.Add(-10 * time.Minute), false},
+		// no handshake nor transfer for longer than idle timeout, closed
+		{now.Add(-10 * time.Minute), true},
+	}
+	for i, tc := range testCases {
+		client.set(devices(tc.handshake))
+		monitor.P <- now.Add(time.Duration(i) * time.Second)
+		if got := <-monitor.idle; got != tc.idle {
+			t.Errorf("case #%d, unexpected idle: got %v, want %v", i, got, tc.idle)
+		}
+	}
+	tracker.Notify(hook.NewTestEvent())
+
+	// events of a snapshot are emitted ordered by endpoint, followed by
+	// events notified from outside
+	want := []string{
+		"state 192.0.2.1:2000 opened",
+		"state 192.0.2.2:1000 opened",
+		"state 192.0.2.1:2000 closed",
+		"state 192.0.2.2:1000 closed",
+		"test",
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(rec.Events()) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := rec.Events(); !slices.Equal(got, want) {
+		t.Errorf("unexpected events: got %q, want %q", got, want)
+	}
+
+	tracker.Stop()
+	<-started
+	<-queried
+	if _, _, err := tracker.Status(); !errors.Is(err, ErrStopped) {
+		t.Errorf("unexpected status error of stopped tracker: %v", err)
+	}
+}
+
+func TestTrackerReplaceMonitor(t *testing.T) {
+	// initial monitor failed to open, its channels never close
+	failed := newTestPoller()
+	tracker := newTracker(&testClient{}, failed)
+	defer tracker.Stop()
+
+	monitor := newTestPoller()
+	if err := tracker.ReplaceMonitor(monitor); err != nil {
+		t.Fatalf("unexpected replace error: %v", err)
+	}
+	select {
+	case monitor.P <- time.Now():
+	case <-time.After(5 * time.Second):
+		t.Fatal("event loop is not reading replacement monitor")
+	}
+	if idle := <-monitor.idle; !idle {
+		t.Error("poll without peers should be idle")
+	}
+}